@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"os"
 
 	"github.com/docopt/docopt-go"
@@ -13,27 +12,117 @@ import (
 )
 
 func main() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	// .env is optional; opal.yaml holds most settings
+	if _, err := os.Stat(".env"); err == nil {
+		if err := godotenv.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "%+v\n", err)
+			os.Exit(1)
+		}
 	}
 
 	opts, err := docopt.ParseDoc(opal.Usage())
 	if err != nil {
-		fmt.Printf("%+v\n", err)
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
 		os.Exit(1)
 	}
 
-	fpath, _ := opts.String("<fpath>")
+	if err := run(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		os.Exit(1)
+	}
+}
 
-	err = opal.Opal(&opal.OpalArgs{
-		Fpath: fpath,
-		Audit: false,
-		Fix:   true,
-	})
+/*
+ * Dispatch to the chosen subcommand. Flags override configuration
+ *
+ */
+func run(opts docopt.Opts) error {
+	fpath, _ := opts.String("<fpath>")
 
+	config, err := opal.LoadVaultConfig(fpath)
 	if err != nil {
-		fmt.Printf("%+v\n", err)
-		os.Exit(1)
+		return err
+	}
+
+	fpath = config.Vault
+	templates, _ := opts.String("--templates")
+	rulesFile, _ := opts.String("--rules")
+	db, _ := opts.String("--db")
+	audit, _ := opts.Bool("--audit")
+	force, _ := opts.Bool("--force")
+	reconcile, _ := opts.Bool("--reconcile")
+	orphans, _ := opts.String("--orphans")
+	merge, _ := opts.Bool("--merge-duplicates")
+	offline, _ := opts.Bool("--offline")
+	indexer, _ := opts.String("--indexer")
+	format, _ := opts.String("--format")
+
+	templates = opal.FirstOf(templates, config.Templates)
+	rulesFile = opal.FirstOf(rulesFile, config.Rules)
+
+	args := &opal.OpalArgs{
+		Fpath:      fpath,
+		Audit:      audit,
+		Fix:        !audit,
+		Force:      force,
+		Reconcile:  reconcile,
+		Orphans:    opal.FirstOf(orphans, config.Orphans),
+		Prefixes:   &config.Tags,
+		Templates:  templates,
+		Rules:      rulesFile,
+		Merge:      merge,
+		Offline:    offline,
+		Db:         opal.FirstOf(db, config.Db),
+		Index:      opal.FirstOf(indexer, config.Indexer),
+		Folders:    config.Folders,
+		Validation: &config.Validation,
+		Format:     format,
+	}
+
+	command := func(name string) bool {
+		set, _ := opts.Bool(name)
+		return set
+	}
+
+	switch {
+	case command("test"):
+		href, _ := opts.String("<url>")
+		title, _ := opts.String("<title>")
+
+		rules, err := opal.LoadRules(opal.RulesSearchPath(rulesFile, ""))
+		if err != nil {
+			return err
+		}
+
+		return opal.TestRules(os.Stdout, rules, href, title)
+	case command("duplicates"):
+		return opal.OpalDuplicates(args)
+	case command("list"):
+		return opal.ListTemplates(os.Stdout, opal.DefaultSources(nil), opal.TemplateSearchPath(templates, fpath))
+	case command("check"):
+		return opal.OpalCheckTemplates(args)
+	case command("fix"):
+		return opal.OpalFix(args)
+	case command("sync") && command("bookmarks"):
+		return opal.OpalSync(args, "bookmarks")
+	case command("sync") && command("stars"):
+		return opal.OpalSync(args, "stars")
+	case command("validate"):
+		return opal.OpalValidate(args)
+	case command("names"):
+		// names are only listed, unless --fix or --audit is given
+		fix, _ := opts.Bool("--fix")
+		return opal.OpalNames(args, fix || audit)
+	case command("mv"):
+		from, _ := opts.String("<old>")
+		to, _ := opts.String("<new>")
+
+		return opal.OpalMove(args, from, to)
+	case command("index"):
+		return opal.OpalIndex(args)
+	case command("status"):
+		return opal.OpalStatus(args)
+	default:
+		return opal.Opal(args)
 	}
 }
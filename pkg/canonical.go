@@ -0,0 +1,260 @@
+package opal
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * Query parameters added by trackers and share buttons; they never change
+ * which page a URL points at
+ */
+var trackingParams = NewSet([]string{
+	"fbclid", "gclid", "dclid", "msclkid", "yclid", "igshid", "mc_cid", "mc_eid",
+	"_hsenc", "_hsmi", "ref_src", "ref_url", "spm",
+})
+
+/*
+ * Query parameters that only track on particular sites; elsewhere, such as
+ * GitHub's `?ref=<branch>`, they select content
+ */
+var siteTrackingParams = map[string]*Set{
+	"medium.com":      NewSet([]string{"source"}),
+	"producthunt.com": NewSet([]string{"ref"}),
+	"twitter.com":     NewSet([]string{"s", "t"}),
+	"x.com":           NewSet([]string{"s", "t"}),
+}
+
+/*
+ * Host labels for mobile and `www.` variants of a site
+ */
+var mobileLabels = NewSet([]string{"m", "mobile"})
+
+/*
+ * Is a query parameter only used for tracking, on this host?
+ *
+ */
+func isTrackingParam(host string, name string) bool {
+	name = strings.ToLower(name)
+
+	if strings.HasPrefix(name, "utm_") || trackingParams.Has(name) {
+		return true
+	}
+
+	for site, params := range siteTrackingParams {
+		if (host == site || strings.HasSuffix(host, "."+site)) && params.Has(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Canonicalise a URL, so the same page bookmarked under different URLs
+ * compares equal. The scheme becomes https; `www.` and mobile subdomains,
+ * default ports, trailing slashes, fragments and tracking parameters are
+ * removed, and the remaining query parameters are sorted. Fragments used
+ * as routes (`#/path` or `#!path`) are kept. Unparsable URLs are returned
+ * trimmed.
+ */
+func CanonicalUrl(href string) string {
+	href = strings.TrimSpace(href)
+
+	parsed, err := url.Parse(href)
+	if err != nil || parsed.Host == "" {
+		return href
+	}
+
+	if parsed.Scheme == "http" || parsed.Scheme == "https" {
+		parsed.Scheme = "https"
+	}
+
+	labels := strings.Split(strings.ToLower(parsed.Hostname()), ".")
+	if len(labels) > 2 && labels[0] == "www" {
+		labels = labels[1:]
+	}
+
+	// m.example.com and en.m.wikipedia.org are mobile variants
+	host := []string{}
+	for idx, label := range labels {
+		if len(labels) > 2 && idx < len(labels)-2 && mobileLabels.Has(label) {
+			continue
+		}
+
+		host = append(host, label)
+	}
+
+	parsed.Host = strings.Join(host, ".")
+	if port := parsed.Port(); port != "" && port != "80" && port != "443" {
+		parsed.Host += ":" + port
+	}
+
+	parsed.Path = strings.TrimRight(parsed.Path, "/")
+	parsed.RawPath = ""
+	parsed.User = nil
+
+	// hash-routed single page apps keep their route in the fragment
+	if !strings.HasPrefix(parsed.Fragment, "/") && !strings.HasPrefix(parsed.Fragment, "!") {
+		parsed.Fragment = ""
+		parsed.RawFragment = ""
+	}
+
+	query := parsed.Query()
+	for name := range query {
+		if isTrackingParam(parsed.Hostname(), name) {
+			query.Del(name)
+		}
+	}
+
+	parsed.RawQuery = query.Encode()
+	parsed.ForceQuery = false
+
+	return parsed.String()
+}
+
+/*
+ * The bookmark's canonical URL
+ *
+ */
+func (book *PinboardBookmark) CanonicalHref() string {
+	return CanonicalUrl(book.href)
+}
+
+/*
+ * Bookmarks sharing a canonical URL, earliest first
+ */
+type BookmarkGroup struct {
+	Canonical string
+	Bookmarks []*PinboardBookmark
+}
+
+/*
+ * Group bookmarks by canonical URL, in order of first appearance
+ *
+ */
+func GroupBookmarks(bookmarks []*PinboardBookmark) []*BookmarkGroup {
+	groups := []*BookmarkGroup{}
+	byUrl := map[string]*BookmarkGroup{}
+
+	for _, book := range bookmarks {
+		canonical := book.CanonicalHref()
+
+		group, ok := byUrl[canonical]
+		if !ok {
+			group = &BookmarkGroup{Canonical: canonical}
+			byUrl[canonical] = group
+			groups = append(groups, group)
+		}
+
+		group.Bookmarks = append(group.Bookmarks, book)
+	}
+
+	for _, group := range groups {
+		sort.SliceStable(group.Bookmarks, func(idx, jdx int) bool {
+			return group.Bookmarks[idx].time < group.Bookmarks[jdx].time
+		})
+	}
+
+	return groups
+}
+
+/*
+ * Groups of more than one bookmark with the same canonical URL
+ *
+ */
+func DuplicateBookmarks(bookmarks []*PinboardBookmark) []*BookmarkGroup {
+	duplicates := []*BookmarkGroup{}
+
+	for _, group := range GroupBookmarks(bookmarks) {
+		if len(group.Bookmarks) > 1 {
+			duplicates = append(duplicates, group)
+		}
+	}
+
+	return duplicates
+}
+
+/*
+ * Print each group of duplicate bookmarks
+ *
+ */
+func ReportDuplicateBookmarks(out io.Writer, groups []*BookmarkGroup) error {
+	for _, group := range groups {
+		if _, err := fmt.Fprintf(out, "%s\n", group.Canonical); err != nil {
+			return err
+		}
+
+		for _, book := range group.Bookmarks {
+			if _, err := fmt.Fprintf(out, "    %s  %s  %s\n", book.hash, book.time, book.href); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := fmt.Fprintf(out, "%d duplicate bookmark group(s)\n", len(groups))
+	return err
+}
+
+/*
+ * Duplicate bookmarks rendered into a single note. The earliest bookmark
+ * provides the note's content; every bookmark's hash is listed under
+ * `bookmark_hash`, and their tags are merged.
+ */
+type MergedBookmark struct {
+	Bookmarks []*PinboardBookmark
+}
+
+func (merged *MergedBookmark) primary() *PinboardBookmark {
+	return merged.Bookmarks[0]
+}
+
+func (merged *MergedBookmark) Identity() string       { return merged.primary().Identity() }
+func (merged *MergedBookmark) Date() string           { return merged.primary().Date() }
+func (merged *MergedBookmark) Title() (string, error) { return merged.primary().Title() }
+
+/*
+ * The hashes of every merged bookmark
+ *
+ */
+func (merged *MergedBookmark) Identities() []string {
+	hashes := []string{}
+
+	for _, book := range merged.Bookmarks {
+		hashes = append(hashes, book.Identity())
+	}
+
+	return hashes
+}
+
+/*
+ * Render the earliest bookmark, then list every hash and tag in the
+ * frontmatter
+ */
+func (merged *MergedBookmark) Render(tmpl *template.Template, date string, prefixes *TagPrefixes) ([]byte, error) {
+	rendered, err := merged.primary().Render(tmpl, date, prefixes)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	for _, book := range merged.Bookmarks {
+		tags = append(tags, book.ObsidianTags(prefixes)...)
+	}
+
+	updated, _, err := MergeFrontmatter(rendered, &FrontmatterUpdate{
+		Tags:  tags,
+		Lists: map[string][]string{"bookmark_hash": merged.Identities()},
+	})
+	if err != nil {
+		return rendered, errors.Wrap(err, "template produced invalid frontmatter")
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,42 @@
+package opal
+
+import (
+	"testing"
+)
+
+func TestCanonicalUrl(t *testing.T) {
+	cases := map[string]string{
+		"http://www.example.com/page/?utm_source=x&b=2&a=1#section": "https://example.com/page?a=1&b=2",
+		"https://en.m.wikipedia.org/wiki/Go":                        "https://en.wikipedia.org/wiki/Go",
+		"https://example.com:443/":                                  "https://example.com",
+		"https://app.example.com/#/a":                               "https://app.example.com#/a",
+		"https://app.example.com/#!/b":                              "https://app.example.com#!/b",
+		"https://github.com/rgrannell1/opal?ref=main":               "https://github.com/rgrannell1/opal?ref=main",
+		"https://www.producthunt.com/posts/opal?ref=home":           "https://producthunt.com/posts/opal",
+		"https://x.com/user/status/1?s=20&t=abc":                    "https://x.com/user/status/1",
+		"not a url":                                                 "not a url",
+	}
+
+	for href, expected := range cases {
+		if actual := CanonicalUrl(href); actual != expected {
+			t.Errorf("CanonicalUrl(%q) = %q, expected %q", href, actual, expected)
+		}
+	}
+}
+
+func TestHashRoutedBookmarksAreNotDuplicates(t *testing.T) {
+	bookmarks := []*PinboardBookmark{
+		{hash: "a", href: "https://app.example.com/#/a", time: "2022-01-01T00:00:00Z"},
+		{hash: "b", href: "https://app.example.com/#/b", time: "2022-01-02T00:00:00Z"},
+		{hash: "c", href: "http://app.example.com/#/a", time: "2022-01-03T00:00:00Z"},
+	}
+
+	groups := DuplicateBookmarks(bookmarks)
+	if len(groups) != 1 || len(groups[0].Bookmarks) != 2 {
+		t.Fatalf("expected one group of two duplicates, got %d group(s)", len(groups))
+	}
+
+	if groups[0].Bookmarks[0].hash != "a" || groups[0].Bookmarks[1].hash != "c" {
+		t.Errorf("unexpected duplicate group %s", groups[0].Canonical)
+	}
+}
@@ -0,0 +1,244 @@
+package opal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * Vault folders each source writes new notes into
+ */
+type FolderConfig struct {
+	Bookmarks string `yaml:"bookmarks"`
+	Stars     string `yaml:"stars"`
+}
+
+/*
+ * Which checks `opal validate` runs
+ */
+type ValidationConfig struct {
+	Missing     bool `yaml:"missing"`
+	Duplicates  bool `yaml:"duplicates"`
+	Links       bool `yaml:"links"`
+	OrphanNotes bool `yaml:"orphan_notes"`
+	DeadEnds    bool `yaml:"dead_ends"`
+	Attachments bool `yaml:"attachments"`
+	Frontmatter bool `yaml:"frontmatter"`
+	Filenames   bool `yaml:"filenames"`
+	// folders excluded from the orphan, dead-end, attachment and filename checks,
+	// in addition to the folders Opal generates notes in
+	Exclude []string `yaml:"exclude"`
+	// frontmatter requirements per folder or tag
+	Schemas []*FrontmatterSchema `yaml:"schemas"`
+}
+
+/*
+ * Opal's configuration. Settings are layered: flags override environment
+ * variables, which override `opal.yaml`, which overrides the defaults.
+ */
+type Config struct {
+	Vault      string           `yaml:"vault"`
+	Db         string           `yaml:"db"`
+	Indexer    string           `yaml:"indexer"`
+	Templates  string           `yaml:"templates"`
+	Rules      string           `yaml:"rules"`
+	Orphans    string           `yaml:"orphans"`
+	Folders    FolderConfig     `yaml:"folders"`
+	Tags       TagPrefixes      `yaml:"tags"`
+	Validation ValidationConfig `yaml:"validation"`
+}
+
+/*
+ * Environment variables, and the settings they override
+ */
+var configEnv = map[string]func(config *Config) *string{
+	"OPAL_VAULT":     func(config *Config) *string { return &config.Vault },
+	"OPAL_DB":        func(config *Config) *string { return &config.Db },
+	"OPAL_INDEXER":   func(config *Config) *string { return &config.Indexer },
+	"OPAL_TEMPLATES": func(config *Config) *string { return &config.Templates },
+	"OPAL_RULES":     func(config *Config) *string { return &config.Rules },
+	"OPAL_ORPHANS":   func(config *Config) *string { return &config.Orphans },
+}
+
+/*
+ * The configuration used when no config file is found
+ *
+ */
+func DefaultConfig() *Config {
+	return &Config{
+		Indexer: IndexerDiatom,
+		Orphans: OrphanReport,
+		Folders: FolderConfig{
+			Bookmarks: (&PinboardSource{}).Folder(),
+			Stars:     (&GithubStarSource{}).Folder(),
+		},
+		Tags: *DefaultTagPrefixes(),
+		Validation: ValidationConfig{
+			Missing:     true,
+			Duplicates:  true,
+			Links:       true,
+			OrphanNotes: true,
+			DeadEnds:    true,
+			Attachments: true,
+			Frontmatter: true,
+			Filenames:   true,
+			Exclude:     []string{},
+			Schemas:     []*FrontmatterSchema{},
+		},
+	}
+}
+
+/*
+ * Config files, lowest priority first: the user config directory's
+ * `opal/opal.yaml` (`$XDG_CONFIG_HOME` on Linux), then the vault's
+ * `.opal/opal.yaml`
+ */
+func ConfigSearchPath(vaultDir string) []string {
+	fpaths := []string{}
+
+	if configDir, err := os.UserConfigDir(); err == nil {
+		fpaths = append(fpaths, filepath.Join(configDir, "opal", "opal.yaml"))
+	}
+
+	if vaultDir != "" {
+		fpaths = append(fpaths, filepath.Join(vaultDir, ".opal", "opal.yaml"))
+	}
+
+	return fpaths
+}
+
+/*
+ * The settings holding file-paths
+ *
+ */
+func (config *Config) paths() []*string {
+	return []*string{&config.Vault, &config.Db, &config.Templates, &config.Rules}
+}
+
+/*
+ * Expand a leading `~` to the user's home directory, as a shell would.
+ * Paths are unchanged if the home directory is unknown.
+ */
+func ExpandHome(fpath string) string {
+	if fpath != "~" && !strings.HasPrefix(fpath, "~/") {
+		return fpath
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fpath
+	}
+
+	return filepath.Join(home, fpath[1:])
+}
+
+/*
+ * Layer each config file that exists over the defaults. Paths starting
+ * with `~` are expanded, and other relative paths are resolved against the
+ * directory of the file that set them.
+ */
+func LoadConfig(fpaths []string) (*Config, error) {
+	config := DefaultConfig()
+
+	for _, fpath := range fpaths {
+		content, err := os.ReadFile(fpath)
+
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		paths := config.paths()
+		previous := []string{}
+		for _, value := range paths {
+			previous = append(previous, *value)
+		}
+
+		if err := yaml.Unmarshal(content, config); err != nil {
+			return nil, errors.Wrap(err, "failed parsing config "+fpath)
+		}
+
+		for idx, value := range paths {
+			if *value == previous[idx] || *value == "" {
+				continue
+			}
+
+			*value = ExpandHome(*value)
+			if !filepath.IsAbs(*value) {
+				*value = filepath.Join(filepath.Dir(fpath), *value)
+			}
+		}
+	}
+
+	return config, nil
+}
+
+/*
+ * Override settings with any OPAL_* environment variables that are set.
+ * Quoted values may hold a `~`, which is expanded.
+ */
+func (config *Config) ApplyEnv() {
+	for name, setting := range configEnv {
+		if value := os.Getenv(name); value != "" {
+			*setting(config) = value
+		}
+	}
+
+	for _, value := range config.paths() {
+		*value = ExpandHome(*value)
+	}
+}
+
+/*
+ * Load configuration for a vault. The vault is the one given, then
+ * OPAL_VAULT, then the vault named in the user's config file.
+ */
+func LoadVaultConfig(vaultDir string) (*Config, error) {
+	if vaultDir == "" {
+		vaultDir = ExpandHome(os.Getenv("OPAL_VAULT"))
+	}
+
+	config, err := LoadConfig(ConfigSearchPath(vaultDir))
+	if err != nil {
+		return nil, err
+	}
+
+	// the vault was named in the user config; its own config applies too
+	if vaultDir == "" && config.Vault != "" {
+		vaultDir = config.Vault
+
+		config, err = LoadConfig(ConfigSearchPath(vaultDir))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config.ApplyEnv()
+
+	if vaultDir != "" {
+		config.Vault = vaultDir
+	}
+
+	return config, nil
+}
+
+/*
+ * Return the first non-empty value, so flags can override configuration
+ *
+ */
+func FirstOf(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
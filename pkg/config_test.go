@@ -0,0 +1,134 @@
+package opal
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+/*
+ * Isolate a test from the user's home and config directories, returning
+ * the temporary home
+ */
+func newTestHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+
+	for name := range configEnv {
+		t.Setenv(name, "")
+	}
+
+	return home
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	home := newTestHome(t)
+	vault := t.TempDir()
+
+	userConfig := filepath.Join(home, ".config", "opal", "opal.yaml")
+	writeTestFile(t, userConfig, "db: ~/opal.sqlite\nindexer: builtin\norphans: tag\nfolders:\n  stars: starred\n")
+	writeTestFile(t, filepath.Join(vault, ".opal", "opal.yaml"), "templates: templates\norphans: archive\n")
+
+	config, err := LoadConfig(ConfigSearchPath(vault))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// later files override earlier ones, and unset keys keep their defaults
+	expected := map[string]string{
+		"db":        filepath.Join(home, "opal.sqlite"),
+		"indexer":   IndexerBuiltin,
+		"orphans":   OrphanArchive,
+		"templates": filepath.Join(vault, ".opal", "templates"),
+		"stars":     "starred",
+		"bookmarks": DefaultConfig().Folders.Bookmarks,
+	}
+
+	actual := map[string]string{
+		"db":        config.Db,
+		"indexer":   config.Indexer,
+		"orphans":   config.Orphans,
+		"templates": config.Templates,
+		"stars":     config.Folders.Stars,
+		"bookmarks": config.Folders.Bookmarks,
+	}
+
+	for key, value := range expected {
+		if actual[key] != value {
+			t.Errorf("%s: expected %q, got %q", key, value, actual[key])
+		}
+	}
+}
+
+func TestLoadVaultConfigFromUserConfig(t *testing.T) {
+	home := newTestHome(t)
+	vault := filepath.Join(home, "notes")
+
+	writeTestFile(t, filepath.Join(home, ".config", "opal", "opal.yaml"), "vault: ~/notes\n")
+	writeTestFile(t, filepath.Join(vault, ".opal", "opal.yaml"), "rules: rules.yaml\n")
+
+	config, err := LoadVaultConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Vault != vault {
+		t.Errorf("expected ~ to expand to %s, got %s", vault, config.Vault)
+	}
+
+	// the vault's own config applies to a vault named in the user config
+	if expected := filepath.Join(vault, ".opal", "rules.yaml"); config.Rules != expected {
+		t.Errorf("expected rules from the vault config, %s, got %s", expected, config.Rules)
+	}
+}
+
+func TestLoadVaultConfigEnvOverrides(t *testing.T) {
+	home := newTestHome(t)
+	vault := t.TempDir()
+
+	writeTestFile(t, filepath.Join(vault, ".opal", "opal.yaml"), "db: vault.sqlite\nindexer: builtin\norphans: tag\n")
+	t.Setenv("OPAL_DB", "~/env.sqlite")
+	t.Setenv("OPAL_ORPHANS", OrphanArchive)
+
+	config, err := LoadVaultConfig(vault)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := filepath.Join(home, "env.sqlite"); config.Db != expected {
+		t.Errorf("expected OPAL_DB to override the config, %s, got %s", expected, config.Db)
+	}
+
+	if config.Orphans != OrphanArchive {
+		t.Errorf("expected OPAL_ORPHANS to override the config, got %s", config.Orphans)
+	}
+
+	if config.Indexer != IndexerBuiltin {
+		t.Errorf("expected the config's indexer without an override, got %s", config.Indexer)
+	}
+
+	// flags override both
+	if FirstOf("flag.sqlite", config.Db) != "flag.sqlite" {
+		t.Error("expected a flag to override the environment")
+	}
+}
+
+func TestExpandHome(t *testing.T) {
+	home := newTestHome(t)
+
+	cases := map[string]string{
+		"~":           home,
+		"~/notes":     filepath.Join(home, "notes"),
+		"~user/notes": "~user/notes",
+		"notes/~":     "notes/~",
+		"":            "",
+	}
+
+	for fpath, expected := range cases {
+		if actual := ExpandHome(fpath); actual != expected {
+			t.Errorf("ExpandHome(%q): expected %q, got %q", fpath, expected, actual)
+		}
+	}
+}
@@ -6,13 +6,115 @@ package opal
 func Usage() string {
 	return `
 	Usage:
-		opal <fpath> [--audit] [--fix]
+		opal fix [<fpath>] [--audit | --fix] [--force] [--db=<file>] [--indexer=<name>]
+		opal sync (bookmarks | stars) [<fpath>] [--audit | --fix] [--reconcile] [--orphans=<mode>] [--templates=<dir>] [--rules=<file>]
+		     [--merge-duplicates] [--offline] [--db=<file>] [--indexer=<name>]
+		opal validate [<fpath>] [--format=<fmt>] [--db=<file>] [--indexer=<name>]
+		opal names [<fpath>] [--audit | --fix] [--format=<fmt>] [--db=<file>] [--indexer=<name>]
+		opal mv <old> <new> [<fpath>] [--audit | --fix] [--db=<file>] [--indexer=<name>]
+		opal index [<fpath>] [--db=<file>] [--indexer=<name>]
+		opal status [<fpath>] [--rules=<file>] [--merge-duplicates] [--db=<file>]
+		opal bookmarks duplicates [--db=<file>]
+		opal templates (list | check) [<fpath>] [--templates=<dir>] [--rules=<file>] [--db=<file>]
+		opal rules test <url> <title> [--rules=<file>]
+		opal [<fpath>] [--audit | --fix] [--force] [--reconcile] [--orphans=<mode>] [--templates=<dir>] [--rules=<file>] [--merge-duplicates] [--offline]
+		     [--db=<file>] [--indexer=<name>]
 		opal (-h | --help)
 
 	Description:
+		Fix Obsidian notes, and sync Pinboard bookmarks and GitHub stars into a vault.
+
+		opal <fpath> runs every step: indexing, fetching with coppermind, fixing notes, syncing bookmarks and stars,
+		re-indexing and validation. Each subcommand runs only its own part:
+
+		opal fix adds missing frontmatter and titles to notes changed since the last run.
+
+		opal sync bookmarks and opal sync stars write notes for new Pinboard bookmarks or GitHub stars, and handle
+		notes whose records were deleted upstream. Coppermind fetches both sources, unless --offline is set.
+
+		opal validate re-indexes the vault, runs every check and reports what it finds as text, JSON or JUnit XML.
+		It fails only if errors are found; warnings are reported. Checks cover the index, broken links, orphan
+		notes, dead ends, unreferenced attachments, filenames, and frontmatter that doesn't parse or match its
+		schemas. opal index only re-indexes the vault.
+
+		opal names lists notes whose filenames break the <date> - <name>.md convention: a missing or invalid date
+		prefix, illegal characters, names over 128 characters, or leading and trailing spaces. Opal skips these
+		notes when fixing. With --fix they are renamed, dated by their created frontmatter or modification time,
+		and every link to them is rewritten; --audit prints the renames instead.
+
+		opal mv renames or moves a note or attachment, and rewrites every wikilink, embed and markdown link to it,
+		keeping headings and aliases. Relative markdown links out of a moved note are updated too. The vault is
+		re-indexed afterwards.
+
+		opal status summarises the index, notes awaiting fixes, and records not yet synced, as of the last index.
+
+		opal templates list shows which template file each source uses. opal templates check renders each
+		template against a few database rows (or built-in samples) and checks the output's frontmatter.
+
+		opal bookmarks duplicates lists bookmarks saved more than once under different URLs; URLs are compared
+		after removing tracking parameters, www. and mobile subdomains, and trailing slashes.
+
+		opal rules test shows how the description rules rewrite a bookmark's title, and which tags they add.
 
 	Arguments:
-		<fpath>    the Obsidian vault directory to analyse or amend
+		<fpath>    the Obsidian vault directory to analyse or amend. Defaults to $OPAL_VAULT, or the vault in opal.yaml
+		<old>      the file to move, relative to the vault
+		<new>      the new path, relative to the vault. Moving into an existing folder keeps the file's name
+		<url>      a bookmark URL
+		<title>    a bookmark title
+
+	Options:
+		--audit              run every step without writing to the vault, and print each note that would be created or modified
+		--fix                write changes to the vault. This is the default
+		--force              process every note, even those unchanged since the last run
+		--reconcile          update existing bookmark and star notes whose upstream data has changed
+		--orphans=<mode>     report, tag or archive notes for bookmarks and stars deleted upstream. Defaults to report
+		--templates=<dir>    a directory of templates, searched before <fpath>/.opal/templates, the user config
+		                     directory's opal/templates folder, and the built-in templates
+		--rules=<file>       a YAML file of bookmark description rules, used instead of <fpath>/.opal/rules.yaml
+		                     or the user config directory's opal/rules.yaml
+		--merge-duplicates   write bookmarks sharing a canonical URL into one note, listing every bookmark_hash
+		--offline            don't fetch bookmarks and stars with coppermind; sync what it last fetched
+		--db=<file>          the SQLite database. Defaults to <fpath>/.opal/opal.sqlite; bookmarks and stars are
+		                     copied into it from coppermind's ~/.diatom.sqlite
+		--indexer=<name>     index notes with diatom, or the built-in indexer. Defaults to diatom
+		--format=<fmt>       print the validation report as text, json or junit [default: text]
+
+	Configuration:
+		Settings are read from the user config directory's opal/opal.yaml (under $XDG_CONFIG_HOME on Linux), then
+		<fpath>/.opal/opal.yaml. Flags override environment variables, which override config files, which override
+		the defaults. A leading ~ in paths is expanded, and relative paths are resolved against the config file's
+		directory. A .env file in the working directory is loaded if present.
+
+		vault: ~/notes                  # or $OPAL_VAULT
+		db: opal.sqlite                 # or $OPAL_DB
+		indexer: builtin                # or $OPAL_INDEXER
+		templates: templates            # or $OPAL_TEMPLATES
+		rules: rules.yaml               # or $OPAL_RULES
+		orphans: tag                    # or $OPAL_ORPHANS
+		folders:
+		  bookmarks: pinboard-bookmarks
+		  stars: github-stars
+		tags:
+		  pinboard: pinboard/
+		  github_topic: github/topic/
+		  github_language: github/language/
+		validation:
+		  missing: true
+		  duplicates: true
+		  links: true
+		  orphan_notes: true
+		  dead_ends: true
+		  attachments: true
+		  exclude: [daily]               # besides the bookmark, star and archive folders
+		  frontmatter: true
+		  filenames: true
+		  schemas:                       # types are string, list, number, bool or date
+		    - folder: github-stars
+		      required: [github_repo]
+		      fields: {tags: list, created: date}
+		    - tag: book
+		      required: [author]
 
 	License:
 	The MIT License
@@ -3,10 +3,6 @@ package opal
 import (
 	"bytes"
 	"fmt"
-	"math/rand"
-	"net/url"
-	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"text/template"
@@ -30,6 +26,7 @@ type PinboardBookmark struct {
 	tags        string
 	time        string
 	toread      string
+	rules       *RuleSet
 }
 
 /*
@@ -41,53 +38,121 @@ func TitleCase(arg string) (interface{}, error) {
 }
 
 /*
- * Load a bookmark template from a file-path, with utility methods.
+ * Parse a bookmark template, with utility methods.
  *
  */
-func LoadBookmarkTemplate(fpath string) (*template.Template, error) {
-	content, err := os.ReadFile(fpath)
-	tmpl := template.New("bookmark").Funcs(template.FuncMap(map[string]interface{}{
-		"TitleCase": TitleCase,
-	}))
+func ParseBookmarkTemplate(content string) (*template.Template, error) {
+	tmpl := template.New("bookmark").Funcs(TemplateFuncs())
 
-	if err != nil {
-		return tmpl, err
-	}
-
-	return tmpl.Parse(string(content))
+	return tmpl.Parse(content)
 }
 
 /*
- * Create a description from a bookmark. Modify sites with unpleasant
- * descriptions.
+ * Pinboard bookmarks, fetched by coppermind into the pinboard_bookmark table
+ *
  */
-func CreateDescription(book *PinboardBookmark) string {
-	url, err := url.Parse(book.href)
-	if err != nil {
-		return book.description
+type PinboardSource struct {
+	Rules           *RuleSet
+	MergeDuplicates bool
+	FolderName      string
+}
+
+func (source *PinboardSource) Kind() string         { return "bookmarks" }
+func (source *PinboardSource) Name() string         { return "pinboard bookmark" }
+func (source *PinboardSource) Key() string          { return "bookmark_hash" }
+func (source *PinboardSource) Block() string        { return "!pinboard" }
+func (source *PinboardSource) TemplateFile() string { return "pinboard-template.txt" }
+func (source *PinboardSource) DeletedTag() string   { return "meta/pinboard/deleted" }
+
+func (source *PinboardSource) TagPrefixes(prefixes *TagPrefixes) []string {
+	return []string{prefixes.Pinboard}
+}
+
+func (source *PinboardSource) Folder() string {
+	return FirstOf(source.FolderName, "pinboard-bookmarks")
+}
+
+func (source *PinboardSource) ParseTemplate(content string) (*template.Template, error) {
+	return ParseBookmarkTemplate(content)
+}
+
+func (source *PinboardSource) Fixtures() []SourceRecord {
+	return []SourceRecord{
+		&PinboardBookmark{
+			description: `Backslashes \ "quotes" and ünïcode: a title`,
+			extended:    "A multi-line\ndescription: with a colon",
+			hash:        "0123456789abcdef0123456789abcdef",
+			href:        "https://en.wikipedia.org/wiki/YAML",
+			shared:      "yes",
+			tags:        "yaml 2022 c++",
+			time:        "2022-02-07T21:51:23Z",
+			toread:      "no",
+			rules:       source.Rules,
+		},
 	}
+}
 
-	if url.Host == "twitter.com" {
-		parts := strings.Split(url.Path, "/")
+func (source *PinboardSource) List(conn *OpalDb) ([]SourceRecord, error) {
+	records := []SourceRecord{}
+
+	bookmarks, err := conn.ListBookmarks()
+	for _, bookmark := range bookmarks {
+		bookmark.rules = source.Rules
+	}
 
-		if len(parts) >= 2 {
-			forbidden := regexp.MustCompile(":-")
-			return "Tweet from " + parts[1] + " on " + forbidden.ReplaceAllString(book.time, "_")
+	if !source.MergeDuplicates {
+		for _, bookmark := range bookmarks {
+			records = append(records, bookmark)
 		}
+
+		return records, err
 	}
 
-	if url.Host == "en.wikipedia.org" {
-		return strings.ReplaceAll(book.description, " - Wikipedia", "")
+	for _, group := range GroupBookmarks(bookmarks) {
+		if len(group.Bookmarks) == 1 {
+			records = append(records, group.Bookmarks[0])
+		} else {
+			records = append(records, &MergedBookmark{Bookmarks: group.Bookmarks})
+		}
 	}
 
-	return book.description
+	return records, err
 }
 
 /*
- * Get a filename for a bookmark
+ * The bookmark hash identifies a bookmark's note
  *
  */
-func (book *PinboardBookmark) FileName() (string, error) {
+func (book *PinboardBookmark) Identity() string {
+	return book.hash
+}
+
+/*
+ * Create a description from a bookmark. Modify sites with unpleasant
+ * descriptions, using the bookmark's description rules.
+ */
+func CreateDescription(book *PinboardBookmark) string {
+	return book.ApplyRules().Description
+}
+
+/*
+ * Apply description rules to the bookmark; the built-in rules are used
+ * if none were configured
+ */
+func (book *PinboardBookmark) ApplyRules() *RuleResult {
+	rules := book.rules
+	if rules == nil {
+		rules = defaultRuleSet
+	}
+
+	return rules.Apply(book.href, book.description, book.time)
+}
+
+/*
+ * Get a filename title for a bookmark
+ *
+ */
+func (book *PinboardBookmark) Title() (string, error) {
 	reg, err := regexp.Compile("[^a-zA-Z0-9- |]+")
 	if err != nil {
 		return "", err
@@ -100,84 +165,122 @@ func (book *PinboardBookmark) FileName() (string, error) {
 		limit = 128
 	}
 
-	date := time.Now().Format("20060101") + fmt.Sprintf("%04d", rand.Intn(10000))
-	fname := date + " - " + strings.Title(strings.ToLower(fragment[0:limit])) + ".md"
-
-	return fname, nil
+	return strings.Title(strings.ToLower(fragment[0:limit])), nil
 }
 
 /*
- * Write a bookmark into a file
+ * The bookmark date, as a filename prefix
  *
  */
-func (book *PinboardBookmark) Write(vault *ObsidianVault, template *template.Template) error {
-	date := time.Now().Format("20060101") + fmt.Sprintf("%04d", rand.Intn(10000))
+func (book *PinboardBookmark) Date() string {
+	return FileDate(time.RFC3339, book.time)
+}
+
+/*
+ * Render a bookmark note from a template. The date is the note's
+ * filename prefix. Pinboard tags are merged into the frontmatter tags.
+ */
+func (book *PinboardBookmark) Render(template *template.Template, date string, prefixes *TagPrefixes) ([]byte, error) {
+	tags := book.ObsidianTags(prefixes)
 
 	view := struct {
-		Date        string
-		Description string
-		Extended    string
-		Hash        string
-		Href        string
-		Meta        string
-		Shared      string
-		Tags        string
-		Time        string
-		Toread      string
+		Date         string
+		Description  string
+		Extended     string
+		Hash         string
+		Href         string
+		Meta         string
+		Shared       string
+		Tags         string
+		ObsidianTags []string
+		Time         string
+		Toread       string
 	}{
-		Date:        date,
-		Description: CreateDescription(book),
-		Extended:    book.extended,
-		Hash:        book.hash,
-		Href:        book.href,
-		Meta:        book.meta,
-		Shared:      book.shared,
-		Tags:        book.tags,
-		Time:        book.time,
-		Toread:      book.toread,
+		Date:         date,
+		Description:  CreateDescription(book),
+		Extended:     book.extended,
+		Hash:         book.hash,
+		Href:         book.href,
+		Meta:         book.meta,
+		Shared:       book.shared,
+		Tags:         book.tags,
+		ObsidianTags: tags,
+		Time:         book.time,
+		Toread:       book.toread,
 	}
 
 	buf := new(bytes.Buffer)
 	if err := template.Execute(buf, view); err != nil {
-		return err
+		return nil, err
 	}
 
-	fname, err := book.FileName()
-	if err != nil {
-		return err
-	}
-
-	fpath := filepath.Join(vault.dpath, "pinboard-bookmarks", fname)
-
-	err = os.MkdirAll(filepath.Join(vault.dpath, "pinboard-bookmarks"), 0700)
-	if err != nil {
-		return errors.Wrap(err, "failed creating pinboard-bookmarks")
-	}
+	return MergeTemplateTags(buf.Bytes(), tags)
+}
 
-	err = os.WriteFile(fpath, buf.Bytes(), 0700)
+/*
+ * Merge tags into a rendered note's frontmatter
+ *
+ */
+func MergeTemplateTags(rendered []byte, tags []string) ([]byte, error) {
+	merged, _, err := MergeFrontmatter(rendered, &FrontmatterUpdate{Tags: tags})
 	if err != nil {
-		return errors.Wrap(err, "failed writing bookmark to file")
+		return rendered, errors.Wrap(err, "template produced invalid frontmatter")
 	}
 
-	return nil
+	return merged, nil
 }
 
 // parse yaml
 type Frontmatter struct {
-	BookmarkHash string `yaml:"bookmark_hash"`
-	GithubRepo   string `yaml:"github_repo"`
+	FileId string
+	Data   map[string]interface{}
 }
 
 /*
- * Enumerate all bookmark hashes present in Obsidian frontmatter, so we
- * can efficiently find bookmark pages that still need to be created
- *
+ * Parse frontmatter YAML, without `---` delimiters, as stored in
+ * diatom's metadata table
  */
-func GetFrontmatter(conn *OpalDb) ([]*Frontmatter, error) {
+func ParseFrontmatter(fileId string, content string) (*Frontmatter, error) {
+	fm := Frontmatter{FileId: fileId, Data: map[string]interface{}{}}
+	err := yaml.Unmarshal([]byte(content), &fm.Data)
+
+	return &fm, err
+}
+
+/*
+ * The string values under a frontmatter key. Keys may hold a single
+ * value or a list of values.
+ */
+func (fm *Frontmatter) Values(key string) []string {
+	values := []string{}
+
+	switch value := fm.Data[key].(type) {
+	case nil:
+	case []interface{}:
+		for _, elem := range value {
+			if elem != nil && fmt.Sprint(elem) != "" {
+				values = append(values, fmt.Sprint(elem))
+			}
+		}
+	default:
+		if fmt.Sprint(value) != "" {
+			values = append(values, fmt.Sprint(value))
+		}
+	}
+
+	return values
+}
+
+/*
+ * Enumerate the frontmatter of a vault's indexed notes, so we can efficiently
+ * find bookmark pages that still need to be created. Other vaults sharing the
+ * database are excluded.
+ */
+func GetFrontmatter(conn *OpalDb, dir string) ([]*Frontmatter, error) {
 	rows, err := conn.Db.Query(`
 	select file_id, content
 	from metadata
-	where schema = "!frontmatter"`)
+	where schema = "!frontmatter" and substr(file_id, 1, length(?1)) = ?1`, VaultPrefix(dir))
 
 	frontmatter := []*Frontmatter{}
 	if err != nil {
@@ -194,109 +297,29 @@ func GetFrontmatter(conn *OpalDb) ([]*Frontmatter, error) {
 			return frontmatter, err
 		}
 
-		fm := Frontmatter{}
-		err = yaml.Unmarshal([]byte(content), &fm)
+		// invalid frontmatter is reported by `opal validate`
+		fm, err := ParseFrontmatter(fileId, content)
 		if err != nil {
 			continue
 		}
 
-		frontmatter = append(frontmatter, &fm)
+		frontmatter = append(frontmatter, fm)
 	}
 
 	return frontmatter, nil
 }
 
 /*
- * Enumerate all bookmark hashes present in Obsidian frontmatter, so we
- * can efficiently find bookmark pages that still need to be created
- *
- */
-func GetPresentBookmarkHashes(conn *OpalDb) (*Set, error) {
-	set := NewSet([]string{})
-	frontmatter, err := GetFrontmatter(conn)
-
-	if err != nil {
-		return set, err
-	}
-
-	for _, fm := range frontmatter {
-		if len(fm.BookmarkHash) > 0 {
-			set.Add(fm.BookmarkHash)
-		}
-	}
-
-	return set, nil
-}
-
-/*
- * Sync pinboard bookmarks into Obsidian, ensuring each hash exists as a
- * metadata header
- */
-func SyncBookmarks(templatePath string, vault *ObsidianVault, conn *OpalDb) error {
-	tmpl, err := LoadBookmarkTemplate(templatePath)
-	if err != nil {
-		return err
-	}
-
-	hashes, err := GetPresentBookmarkHashes(conn)
-	if err != nil {
-		return err
-	}
-
-	bookmarks, err := conn.ListAbsentBookmarks(hashes)
-	if err != nil {
-		return err
-	}
-
-	// write bookmarks to Obsidian
-	for _, bookmark := range bookmarks {
-		err = bookmark.Write(vault, tmpl)
-
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-/*
- * Load a github star template from a file-path, with utility methods.
+ * Parse a github star template, with utility methods.
  */
-func LoadGithubStarTemplate(fpath string) (*template.Template, error) {
-	content, err := os.ReadFile(fpath)
-	tmpl := template.New("github").Funcs(template.FuncMap(map[string]interface{}{
-		"TitleCase": TitleCase,
-	}))
+func ParseGithubStarTemplate(content string) (*template.Template, error) {
+	tmpl := template.New("github").Funcs(TemplateFuncs())
 
-	if err != nil {
-		return tmpl, err
-	}
-
-	return tmpl.Parse(string(content))
-}
-
-/*
- *
- */
-func GetPresentGithubStars(conn *OpalDb) (*Set, error) {
-	set := NewSet([]string{})
-	frontmatter, err := GetFrontmatter(conn)
-
-	if err != nil {
-		return set, err
-	}
-
-	for _, fm := range frontmatter {
-		if len(fm.GithubRepo) > 0 {
-			set.Add(fm.GithubRepo)
-		}
-	}
-
-	return set, nil
+	return tmpl.Parse(content)
 }
 
 /*
+ * A starred GitHub repository
  *
  */
 type StarredRepository struct {
@@ -306,84 +329,123 @@ type StarredRepository struct {
 	Url         string
 	Language    string
 	Topics      string
+	StarredAt   string
 }
 
 /*
- *
- *
+ * Render a starred repository note from a template. Topics and language
+ * are merged into the frontmatter tags.
  */
-func (repo *StarredRepository) Write(vault *ObsidianVault, template *template.Template) error {
-	date := time.Now().Format("20060102") + fmt.Sprintf("%04d", rand.Intn(10000))
+func (repo *StarredRepository) Render(template *template.Template, date string, prefixes *TagPrefixes) ([]byte, error) {
+	tags := repo.ObsidianTags(prefixes)
+
 	view := struct {
-		Name        string
-		Description string
-		Login       string
-		Url         string
-		Language    string
-		Topics      string
+		Date         string
+		Name         string
+		Description  string
+		Login        string
+		Url          string
+		Language     string
+		Topics       string
+		ObsidianTags []string
+		StarredAt    string
 	}{
-		Name:        repo.Name,
-		Description: repo.Description,
-		Login:       repo.Login,
-		Url:         repo.Url,
-		Language:    repo.Language,
-		Topics:      repo.Topics,
+		Date:         date,
+		Name:         repo.Name,
+		Description:  repo.Description,
+		Login:        repo.Login,
+		Url:          repo.Url,
+		Language:     repo.Language,
+		Topics:       repo.Topics,
+		ObsidianTags: tags,
+		StarredAt:    repo.StarredAt,
 	}
 
 	buf := new(bytes.Buffer)
 	if err := template.Execute(buf, view); err != nil {
-		return err
+		return nil, err
 	}
 
-	reg, err := regexp.Compile("/+")
-	if err != nil {
-		return err
-	}
+	return MergeTemplateTags(buf.Bytes(), tags)
+}
 
-	name := reg.ReplaceAllString(repo.Name, " ")
-	fname := date + " - " + strings.Title(strings.ToLower(name)) + ".md"
-	fpath := filepath.Join(vault.dpath, "github-stars", fname)
+/*
+ * The repository name identifies a star's note
+ *
+ */
+func (repo *StarredRepository) Identity() string {
+	return repo.Name
+}
 
-	err = os.MkdirAll(filepath.Join(vault.dpath, "github-stars"), 0700)
-	if err != nil {
-		return errors.Wrap(err, "failed creating github-stars")
-	}
+/*
+ * The star date, as a filename prefix. Coppermind does not currently
+ * record when a repository was starred, so this is usually UnknownFileDate.
+ */
+func (repo *StarredRepository) Date() string {
+	return FileDate(time.RFC3339, repo.StarredAt)
+}
 
-	err = os.WriteFile(fpath, buf.Bytes(), 0700)
+/*
+ * Get a filename title for a starred repository
+ *
+ */
+func (repo *StarredRepository) Title() (string, error) {
+	reg, err := regexp.Compile("/+")
 	if err != nil {
-		return errors.Wrap(err, "failed writing bookmark to file")
+		return "", err
 	}
 
-	return nil
+	return strings.Title(strings.ToLower(reg.ReplaceAllString(repo.Name, " "))), nil
 }
 
 /*
- * Sync github stars into Obsidian
+ * GitHub stars, fetched by coppermind into the github_star table
  *
  */
-func SyncGithubStars(templatePath string, vault *ObsidianVault, conn *OpalDb) error {
-	tmpl, err := LoadGithubStarTemplate(templatePath)
-	if err != nil {
-		return err
-	}
+type GithubStarSource struct {
+	FolderName string
+}
 
-	repoNames, err := GetPresentGithubStars(conn)
-	if err != nil {
-		return err
-	}
+func (source *GithubStarSource) Kind() string         { return "stars" }
+func (source *GithubStarSource) Name() string         { return "github star" }
+func (source *GithubStarSource) Key() string          { return "github_repo" }
+func (source *GithubStarSource) Block() string        { return "!github" }
+func (source *GithubStarSource) TemplateFile() string { return "github-template.txt" }
+func (source *GithubStarSource) DeletedTag() string   { return "meta/github/star/deleted" }
 
-	repos, err := conn.ListAbsentGithubStars(repoNames)
-	if err != nil {
-		return err
+func (source *GithubStarSource) TagPrefixes(prefixes *TagPrefixes) []string {
+	return []string{prefixes.GithubTopic, prefixes.GithubLanguage}
+}
+
+func (source *GithubStarSource) Folder() string {
+	return FirstOf(source.FolderName, "github-stars")
+}
+
+func (source *GithubStarSource) ParseTemplate(content string) (*template.Template, error) {
+	return ParseGithubStarTemplate(content)
+}
+
+func (source *GithubStarSource) Fixtures() []SourceRecord {
+	return []SourceRecord{
+		&StarredRepository{
+			Name:        "rgrannell1/opal",
+			Description: `Fix "Obsidian" notes: a C:\ path`,
+			Login:       "rgrannell1",
+			Url:         "https://github.com/rgrannell1/opal",
+			Language:    "Go",
+			Topics:      `["obsidian", "note-taking"]`,
+			StarredAt:   "2022-02-07T21:51:23Z",
+		},
 	}
+}
 
-	for _, repo := range repos {
-		err = repo.Write(vault, tmpl)
+func (source *GithubStarSource) List(conn *OpalDb) ([]SourceRecord, error) {
+	records := []SourceRecord{}
 
-		if err != nil {
-			return err
-		}
+	stars, err := conn.ListGithubStars()
+	for _, star := range stars {
+		records = append(records, star)
 	}
 
-	return nil
+	return records, err
 }
@@ -2,8 +2,28 @@ package opal
 
 import (
 	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
 )
 
+/*
+ * Coppermind creates its tables on first fetch; syncing from a database
+ * without them is an error, rather than silently syncing nothing
+ */
+var ErrMissingTable = errors.New("table not found")
+
+/*
+ * The prefix shared by the ids of a vault's files in the index. Vaults
+ * are indexed by absolute path, so vaults sharing a database never overlap.
+ */
+func VaultPrefix(dir string) string {
+	sep := string(filepath.Separator)
+	return strings.TrimSuffix(filepath.Clean(dir), sep) + sep
+}
+
 type OpalDb struct {
 	Db *sql.DB
 }
@@ -14,11 +34,10 @@ type OpalDb struct {
  */
 func (conn *OpalDb) CreateTables() error {
 	tx, err := conn.Db.Begin()
-	defer tx.Rollback()
-
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
 	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS opal_metadata (
 		id                TEXT NOT NULL,
@@ -61,46 +80,70 @@ func (conn *OpalDb) ReadTitle() (string, error) {
 }
 
 /*
- *
+ * Record the current diatom hash of a file as its processed hash. Files
+ * absent from the diatom file table are skipped.
  */
-func (conn *OpalDb) WriteProcessedHash() (string, error) {
-	return "", nil
+func (conn *OpalDb) WriteProcessedHash(tx *sql.Tx, fpath string) error {
+	var hash string
+	row := tx.QueryRow(`SELECT hash FROM file WHERE file.id = ?`, fpath)
+
+	err := row.Scan(&hash)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO opal_metadata (id, processed_hash) VALUES (?, ?)`, fpath, hash)
+
+	return err
 }
 
 /*
- * Fetch the file hash, and opal hash
- *
+ * Fetch the file hash, and opal hash. Files opal has not processed
+ * have an empty processed hash.
  */
 func (conn *OpalDb) GetHashes(fpath string) (string, string, error) {
 	var hash string
 	row := conn.Db.QueryRow(`SELECT hash FROM file WHERE file.id = ?`, fpath)
 
 	err := row.Scan(&hash)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", "", nil
-		}
+	if err != nil && err != sql.ErrNoRows {
+		return "", "", err
 	}
 
 	var processedHash string
 	opalRow := conn.Db.QueryRow(`SELECT processed_hash FROM opal_metadata WHERE id = ?`, fpath)
 
-	if opalRow == nil {
-		return hash, "", nil
-	}
-
 	err = opalRow.Scan(&processedHash)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", "", nil
-		}
+	if err != nil && err != sql.ErrNoRows {
+		return "", "", err
 	}
 
 	return hash, processedHash, nil
 }
 
+/*
+ * Mark notes as processed, by storing their post-fix hashes in a single
+ * transaction. This should run after diatom has re-indexed the vault, so
+ * the stored hashes reflect opal's own modifications.
+ */
 func (conn *OpalDb) MarkComplete(notes []*ObsidianNote) error {
-	return nil
+	tx, err := conn.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, note := range notes {
+		if err := conn.WriteProcessedHash(tx, note.fpath); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 func (conn *OpalDb) GetFrontmatter() error {
@@ -108,12 +151,16 @@ func (conn *OpalDb) GetFrontmatter() error {
 }
 
 /*
- * List bookmarks not present
+ * List all bookmarks
  *
  */
-func (conn *OpalDb) ListAbsentBookmarks(hashes *Set) ([]*PinboardBookmark, error) {
+func (conn *OpalDb) ListBookmarks() ([]*PinboardBookmark, error) {
 	bookmarks := make([]*PinboardBookmark, 0)
 
+	if err := conn.RequireTable("pinboard_bookmark"); err != nil {
+		return bookmarks, err
+	}
+
 	rows, err := conn.Db.Query(`
 	SELECT description, extended, hash, href, meta, shared, tags, time, toread FROM pinboard_bookmark
 	`)
@@ -138,9 +185,7 @@ func (conn *OpalDb) ListAbsentBookmarks(hashes *Set) ([]*PinboardBookmark, error
 			return bookmarks, err
 		}
 
-		if !hashes.Has(bookmark.hash) {
-			bookmarks = append(bookmarks, &bookmark)
-		}
+		bookmarks = append(bookmarks, &bookmark)
 	}
 
 	err = rows.Close()
@@ -152,13 +197,76 @@ func (conn *OpalDb) ListAbsentBookmarks(hashes *Set) ([]*PinboardBookmark, error
 }
 
 /*
- * List bookmarks not present
+ * Does a table exist?
+ *
+ */
+func (conn *OpalDb) HasTable(table string) (bool, error) {
+	var count int
+	row := conn.Db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table)
+
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+/*
+ * Fail with ErrMissingTable unless a table exists
+ *
+ */
+func (conn *OpalDb) RequireTable(table string) error {
+	exists, err := conn.HasTable(table)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return fmt.Errorf("%s %w in the database; fetch with coppermind, or choose another with --db", table, ErrMissingTable)
+	}
+
+	return nil
+}
+
+/*
+ * Does a table have a particular column?
+ *
+ */
+func (conn *OpalDb) HasColumn(table string, column string) (bool, error) {
+	var count int
+	row := conn.Db.QueryRow(`SELECT count(*) FROM pragma_table_info(?) WHERE name = ?`, table, column)
+
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+/*
+ * List all github stars
  *
  */
-func (conn *OpalDb) ListAbsentGithubStars(stars *Set) ([]*StarredRepository, error) {
+func (conn *OpalDb) ListGithubStars() ([]*StarredRepository, error) {
 	starred := make([]*StarredRepository, 0)
 
-	rows, err := conn.Db.Query(`SELECT name, description, login, url, language, topics from github_star`)
+	if err := conn.RequireTable("github_star"); err != nil {
+		return starred, err
+	}
+
+	// coppermind's github_star table has no starred_at column; it is read
+	// only if present, and stars are otherwise named with UnknownFileDate
+	starredAt := "''"
+	hasStarredAt, err := conn.HasColumn("github_star", "starred_at")
+	if err != nil {
+		return starred, err
+	}
+
+	if hasStarredAt {
+		starredAt = "starred_at"
+	}
+
+	rows, err := conn.Db.Query(`SELECT name, description, login, url, language, topics, ` + starredAt + ` from github_star`)
 	if err != nil {
 		return starred, err
 	}
@@ -172,15 +280,14 @@ func (conn *OpalDb) ListAbsentGithubStars(stars *Set) ([]*StarredRepository, err
 			&repo.Url,
 			&repo.Language,
 			&repo.Topics,
+			&repo.StarredAt,
 		)
 
 		if err != nil {
 			return starred, err
 		}
 
-		if !stars.Has(repo.Name) {
-			starred = append(starred, &repo)
-		}
+		starred = append(starred, &repo)
 	}
 
 	err = rows.Close()
@@ -192,13 +299,13 @@ func (conn *OpalDb) ListAbsentGithubStars(stars *Set) ([]*StarredRepository, err
 }
 
 /*
- * Fetch the file hash, and opal hash
+ * List the id and hash of each of a vault's indexed files
  *
  */
-func (conn *OpalDb) ListHashes() ([][]string, error) {
+func (conn *OpalDb) ListHashes(dir string) ([][]string, error) {
 	pairs := make([][]string, 0)
 
-	rows, err := conn.Db.Query(`select id, hash from file`)
+	rows, err := conn.Db.Query(`select id, hash from file where substr(id, 1, length(?1)) = ?1`, VaultPrefix(dir))
 	if err != nil {
 		return pairs, err
 	}
@@ -0,0 +1,60 @@
+package opal
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * Open a database in a temporary directory, with Opal's tables
+ *
+ */
+func newTestDb(t *testing.T) (*OpalDb, string) {
+	t.Helper()
+	dbpath := filepath.Join(t.TempDir(), "opal.sqlite")
+
+	conn, err := NewOpalDb(dbpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Db.Close() })
+
+	if err := conn.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	return conn, dbpath
+}
+
+func TestDatabasePathDefaultsToVault(t *testing.T) {
+	if dbpath, _ := DatabasePath("", "notes"); dbpath != filepath.Join("notes", ".opal", "opal.sqlite") {
+		t.Errorf("expected the vault's database, got %s", dbpath)
+	}
+
+	if dbpath, _ := DatabasePath("custom.sqlite", "notes"); dbpath != "custom.sqlite" {
+		t.Errorf("expected the configured database, got %s", dbpath)
+	}
+
+	expected, err := CoppermindDatabasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dbpath, _ := DatabasePath("", ""); dbpath != expected {
+		t.Errorf("expected coppermind's database without a vault, got %s", dbpath)
+	}
+}
+
+func TestListSourcesRequiresTables(t *testing.T) {
+	conn, _ := newTestDb(t)
+
+	if _, err := conn.ListBookmarks(); !errors.Is(err, ErrMissingTable) {
+		t.Errorf("expected ErrMissingTable for bookmarks, got %v", err)
+	}
+
+	if _, err := conn.ListGithubStars(); !errors.Is(err, ErrMissingTable) {
+		t.Errorf("expected ErrMissingTable for stars, got %v", err)
+	}
+}
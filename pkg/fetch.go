@@ -0,0 +1,166 @@
+package opal
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	copper "github.com/rgrannell1/coppermind/pkg"
+)
+
+/*
+ * The tables coppermind fetches bookmarks and stars into
+ *
+ */
+var CoppermindTables = []string{"pinboard_bookmark", "github_star"}
+
+/*
+ * Fetches external data into the database before a sync. Coppermind reaches
+ * out to Pinboard and GitHub; tests can substitute a fake that seeds the
+ * `pinboard_bookmark` and `github_star` tables instead.
+ */
+type Fetcher interface {
+	Fetch(dbpath string) error
+}
+
+/*
+ * Fetch bookmarks and stars with Coppermind, which writes into its own
+ * database, then copy them into the vault's
+ */
+type CoppermindFetcher struct{}
+
+func (fetcher *CoppermindFetcher) Fetch(dbpath string) error {
+	if err := copper.Coppermind(); err != nil {
+		return err
+	}
+
+	return ImportCoppermindTables(dbpath)
+}
+
+/*
+ * Fetch nothing from the network, syncing whatever coppermind last fetched
+ *
+ */
+type OfflineFetcher struct{}
+
+func (fetcher *OfflineFetcher) Fetch(dbpath string) error {
+	return ImportCoppermindTables(dbpath)
+}
+
+/*
+ * Copy coppermind's tables into a database, replacing earlier copies.
+ * Nothing is copied when the database is coppermind's own, or when
+ * coppermind has not created a table yet.
+ */
+func ImportCoppermindTables(dbpath string) error {
+	source, err := CoppermindDatabasePath()
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(source); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	same, err := samePath(source, dbpath)
+	if err != nil || same {
+		return err
+	}
+
+	conn, err := NewOpalDb(dbpath)
+	if err != nil {
+		return err
+	}
+	defer conn.Db.Close()
+
+	// ATTACH applies to a single connection, and can't run in a transaction
+	ctx := context.Background()
+	db, err := conn.Db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, `ATTACH DATABASE ? AS coppermind`, source); err != nil {
+		return errors.Wrap(err, "failed opening coppermind's database")
+	}
+	defer db.ExecContext(ctx, `DETACH DATABASE coppermind`)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range CoppermindTables {
+		if err := copyTable(tx, table); err != nil {
+			return errors.Wrap(err, "failed copying "+table+" from coppermind's database")
+		}
+	}
+
+	return tx.Commit()
+}
+
+/*
+ * Recreate an attached coppermind table in the main database
+ *
+ */
+func copyTable(tx *sql.Tx, table string) error {
+	var schema string
+	row := tx.QueryRow(`SELECT sql FROM coppermind.sqlite_master WHERE type = 'table' AND name = ?`, table)
+
+	err := row.Scan(&schema)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	statements := []string{
+		`DROP TABLE IF EXISTS main.` + table,
+		schema,
+		`INSERT INTO main.` + table + ` SELECT * FROM coppermind.` + table,
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Do two paths name the same file?
+ *
+ */
+func samePath(first string, second string) (bool, error) {
+	first, err := filepath.Abs(first)
+	if err != nil {
+		return false, err
+	}
+
+	second, err = filepath.Abs(second)
+	if err != nil {
+		return false, err
+	}
+
+	return first == second, nil
+}
+
+/*
+ * The fetcher for a run; offline runs never touch the network
+ *
+ */
+func DefaultFetcher(offline bool) Fetcher {
+	if offline {
+		return &OfflineFetcher{}
+	}
+
+	return &CoppermindFetcher{}
+}
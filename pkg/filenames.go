@@ -0,0 +1,215 @@
+package opal
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const CheckFilenames = "filenames"
+
+/*
+ * The longest note name allowed, excluding the date prefix and extension
+ */
+const MaxNameLength = 128
+
+/*
+ * Notes are named `<numeric date> - <name>.md`
+ */
+var noteNamePattern = regexp.MustCompile(`^(\d+) - (.*)\.md$`)
+
+/*
+ * Characters Obsidian or common filesystems reject in filenames or links
+ */
+var illegalNameChars = regexp.MustCompile(`[\\/:*?"<>|#^\[\]]`)
+
+/*
+ * Split a note filename into its date prefix and name. Names without a
+ * prefix are returned whole, without their extension.
+ */
+func splitNoteName(fname string) (string, string) {
+	if match := noteNamePattern.FindStringSubmatch(fname); match != nil {
+		return match[1], match[2]
+	}
+
+	return "", strings.TrimSuffix(fname, filepath.Ext(fname))
+}
+
+/*
+ * Does a prefix begin with a valid `20060102` date?
+ *
+ */
+func validDatePrefix(prefix string) bool {
+	if len(prefix) < 8 {
+		return false
+	}
+
+	_, err := time.Parse("20060102", prefix[0:8])
+	return err == nil
+}
+
+/*
+ * The ways a note filename breaks the naming convention
+ *
+ */
+func FilenameProblems(fname string) []string {
+	problems := []string{}
+	prefix, name := splitNoteName(fname)
+
+	if prefix == "" {
+		problems = append(problems, "missing date prefix")
+	} else if !validDatePrefix(prefix) {
+		problems = append(problems, "invalid date prefix "+prefix)
+	}
+
+	if illegalNameChars.MatchString(name) {
+		problems = append(problems, "illegal characters in name")
+	}
+
+	if utf8.RuneCountInString(name) > MaxNameLength {
+		problems = append(problems, "name is over 128 characters")
+	}
+
+	if name != strings.TrimSpace(name) {
+		problems = append(problems, "leading or trailing spaces in name")
+	}
+
+	return problems
+}
+
+/*
+ * Report notes whose filenames break the `<date> - <name>.md` convention;
+ * Opal skips these notes when fixing frontmatter and titles
+ */
+func CheckNoBadFilenames(vault *ObsidianVault, exclude []string, report *ValidationReport) error {
+	fpaths, err := vault.ListMarkdown()
+	if err != nil {
+		return err
+	}
+
+	for _, fpath := range fpaths {
+		if vault.InFolders(fpath, exclude) {
+			continue
+		}
+
+		for _, problem := range FilenameProblems(filepath.Base(fpath)) {
+			report.Add(CheckFilenames, SeverityWarning, fpath, problem)
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Clean a note name: illegal characters become spaces, runs of whitespace
+ * collapse, and long names are truncated
+ */
+func CleanNoteName(name string) string {
+	name = strings.Join(strings.Fields(illegalNameChars.ReplaceAllString(name, " ")), " ")
+
+	if utf8.RuneCountInString(name) > MaxNameLength {
+		name = strings.TrimSpace(string([]rune(name)[0:MaxNameLength]))
+	}
+
+	if name == "" {
+		return "Untitled"
+	}
+
+	return name
+}
+
+/*
+ * The date a note was created, as a filename prefix: the `created`
+ * frontmatter date if present, otherwise the file's modification time,
+ * as creation times are not portably available.
+ */
+func (vault *ObsidianVault) NoteDate(fpath string) (string, error) {
+	content, err := vault.plan.ReadFile(fpath)
+	if err != nil {
+		return "", err
+	}
+
+	block, _ := SplitFrontmatter(content)
+	if fm, err := ParseFrontmatterDocument(block); err == nil {
+		created := fm.GetString("created")
+
+		for _, layout := range isoDateLayouts {
+			if date, err := time.Parse(layout, created); err == nil {
+				return date.Format("20060102"), nil
+			}
+		}
+	}
+
+	info, err := os.Stat(fpath)
+	if err != nil {
+		return "", err
+	}
+
+	return info.ModTime().Format("20060102"), nil
+}
+
+/*
+ * Choose a conventional filename for a note, in the same folder. Valid date
+ * prefixes are kept; clashing names get a stable suffix on the date.
+ */
+func (vault *ObsidianVault) ConventionalPath(fpath string, taken *Set) (string, error) {
+	prefix, name := splitNoteName(filepath.Base(fpath))
+
+	if !validDatePrefix(prefix) {
+		date, err := vault.NoteDate(fpath)
+		if err != nil {
+			return "", err
+		}
+
+		prefix = date
+	}
+
+	name = CleanNoteName(name)
+	dir := filepath.Dir(fpath)
+	target := filepath.Join(dir, prefix+" - "+name+".md")
+
+	for attempt := 0; attempt < MaxNameAttempts; attempt++ {
+		if _, err := os.Stat(target); errors.Is(err, os.ErrNotExist) && !taken.Has(target) {
+			return target, nil
+		}
+
+		target = filepath.Join(dir, prefix+StableSuffix(vault.RelativePath(fpath), attempt)+" - "+name+".md")
+	}
+
+	return "", errors.New("could not find a free filename for " + fpath)
+}
+
+/*
+ * Rename every note breaking the naming convention, rewriting the links
+ * that point at each renamed note
+ */
+func (vault *ObsidianVault) FixFilenames(exclude []string) error {
+	graph, err := vault.NewLinkGraph()
+	if err != nil {
+		return err
+	}
+
+	renames := []*Rename{}
+	taken := NewSet([]string{})
+
+	for _, fpath := range graph.Notes {
+		if vault.InFolders(fpath, exclude) || len(FilenameProblems(filepath.Base(fpath))) == 0 {
+			continue
+		}
+
+		target, err := vault.ConventionalPath(fpath, taken)
+		if err != nil {
+			return err
+		}
+
+		taken.Add(target)
+		renames = append(renames, &Rename{From: fpath, To: target})
+	}
+
+	return vault.MoveFiles(graph, renames, "filename does not follow the naming convention")
+}
@@ -0,0 +1,71 @@
+package opal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilenameProblems(t *testing.T) {
+	cases := map[string][]string{
+		"20220101 - Hello.md":                            {},
+		"202201010001 - Hello.md":                        {},
+		"Hello.md":                                       {"missing date prefix"},
+		"2022 - Hello.md":                                {"invalid date prefix 2022"},
+		"20221301 - Hello.md":                            {"invalid date prefix 20221301"},
+		"20220101 - What? A: title.md":                   {"illegal characters in name"},
+		"20220101 -  Hello .md":                          {"leading or trailing spaces in name"},
+		"20220101 - " + strings.Repeat("a", 129) + ".md": {"name is over 128 characters"},
+	}
+
+	for fname, expected := range cases {
+		if actual := FilenameProblems(fname); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("FilenameProblems(%q) = %v, expected %v", fname, actual, expected)
+		}
+	}
+}
+
+func TestCleanNoteName(t *testing.T) {
+	cases := map[string]string{
+		" What?  A: title ":      "What A title",
+		"[[link]] #tag":          "link tag",
+		"***":                    "Untitled",
+		strings.Repeat("é", 200): strings.Repeat("é", MaxNameLength),
+	}
+
+	for name, expected := range cases {
+		if actual := CleanNoteName(name); actual != expected {
+			t.Errorf("CleanNoteName(%q) = %q, expected %q", name, actual, expected)
+		}
+	}
+}
+
+func TestFixFilenamesRewritesLinks(t *testing.T) {
+	vault := newTestVault(t, map[string]string{
+		"Dated.md":            "---\ncreated: 2021-03-04\n---\n# Dated\n",
+		"Undated * note.md":   "# Undated\n",
+		"20220101 - Index.md": "`[[Dated]]` [[Dated]] [[Dated|again]]\n[u](<Undated * note.md>)\n",
+		"archive/Skipped.md":  "",
+	})
+
+	modified := time.Date(2020, 5, 6, 0, 0, 0, 0, time.Local)
+	if err := os.Chtimes(filepath.Join(vault.dpath, "Undated * note.md"), modified, modified); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := vault.FixFilenames([]string{"archive"}); err != nil {
+		t.Fatal(err)
+	}
+
+	readTestFile(t, vault, "20210304 - Dated.md")
+	readTestFile(t, vault, "20200506 - Undated note.md")
+	readTestFile(t, vault, "archive/Skipped.md")
+
+	expected := "`[[Dated]]` [[20210304 - Dated]] [[20210304 - Dated|again]]\n[u](<20200506 - Undated note.md>)\n"
+	if actual := readTestFile(t, vault, "20220101 - Index.md"); actual != expected {
+		t.Errorf("unexpected links after renaming:\n%s", actual)
+	}
+}
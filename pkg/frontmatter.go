@@ -0,0 +1,565 @@
+package opal
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * A frontmatter key Opal computes for a note. Overridden keys are always
+ * rewritten; other keys are only added when absent, so user edits win.
+ */
+type FrontmatterField struct {
+	Key      string
+	Value    string
+	Tag      string
+	Override bool
+}
+
+/*
+ * A set of Opal-computed changes to merge into a note's frontmatter.
+ * Values in Lists are added to the list under each key, like tags. Tags
+ * under an owned prefix are Opal's, and are removed unless listed in Tags.
+ */
+type FrontmatterUpdate struct {
+	Fields      []*FrontmatterField
+	Tags        []string
+	OwnedPrefix []string
+	Lists       map[string][]string
+}
+
+/*
+ * A parsed frontmatter block. The yaml.v3 node tree is kept so unknown keys,
+ * key order, comments and quoting style survive a round-trip.
+ */
+type FrontmatterDocument struct {
+	doc *yaml.Node
+}
+
+/*
+ * Parse a frontmatter block, with or without `---` delimiters. Empty blocks
+ * produce an empty mapping.
+ */
+func ParseFrontmatterDocument(block []byte) (*FrontmatterDocument, error) {
+	doc := yaml.Node{}
+
+	if err := yaml.Unmarshal(StripFrontmatterDelimiters(block), &doc); err != nil {
+		return nil, err
+	}
+
+	if doc.Kind == 0 {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+	}
+
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("frontmatter is not a YAML mapping")
+	}
+
+	return &FrontmatterDocument{&doc}, nil
+}
+
+/*
+ * Remove the `---` delimiter lines surrounding a frontmatter block
+ *
+ */
+func StripFrontmatterDelimiters(block []byte) []byte {
+	lines := bytes.SplitAfter(block, []byte("\n"))
+
+	if len(lines) > 0 && string(bytes.TrimRight(lines[0], "\r\n")) == "---" {
+		lines = lines[1:]
+	}
+
+	for idx := len(lines) - 1; idx >= 0; idx-- {
+		trimmed := string(bytes.TrimRight(lines[idx], "\r\n"))
+
+		if trimmed == "" && idx == len(lines)-1 {
+			continue
+		}
+
+		if trimmed == "---" || trimmed == "..." {
+			lines = lines[:idx]
+		}
+
+		break
+	}
+
+	return bytes.Join(lines, []byte{})
+}
+
+func (fm *FrontmatterDocument) mapping() *yaml.Node {
+	return fm.doc.Content[0]
+}
+
+/*
+ * Get the value node for a top-level key, or nil if absent
+ *
+ */
+func (fm *FrontmatterDocument) Get(key string) *yaml.Node {
+	mapping := fm.mapping()
+
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		if mapping.Content[idx].Value == key {
+			return mapping.Content[idx+1]
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Get a top-level scalar value, or an empty string if absent or not a scalar
+ *
+ */
+func (fm *FrontmatterDocument) GetString(key string) string {
+	node := fm.Get(key)
+
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return ""
+	}
+
+	return node.Value
+}
+
+/*
+ * Append a key-value pair to the end of the mapping
+ *
+ */
+func (fm *FrontmatterDocument) append(key string, value *yaml.Node) {
+	mapping := fm.mapping()
+
+	mapping.Content = append(mapping.Content, &yaml.Node{
+		Kind:  yaml.ScalarNode,
+		Tag:   "!!str",
+		Value: key,
+	}, value)
+}
+
+/*
+ * Set a scalar value, keeping the existing node's quoting style and comments.
+ * Returns whether the frontmatter changed.
+ */
+func (fm *FrontmatterDocument) Set(key string, value string, tag string) bool {
+	node := fm.Get(key)
+
+	if node == nil {
+		fm.append(key, &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value})
+		return true
+	}
+
+	if node.Kind == yaml.ScalarNode && node.Value == value {
+		return false
+	}
+
+	*node = yaml.Node{
+		Kind:        yaml.ScalarNode,
+		Tag:         tag,
+		Value:       value,
+		Style:       node.Style &^ (yaml.LiteralStyle | yaml.FoldedStyle | yaml.FlowStyle),
+		HeadComment: node.HeadComment,
+		LineComment: node.LineComment,
+		FootComment: node.FootComment,
+	}
+
+	return true
+}
+
+/*
+ * Set a scalar value only if the key is absent. Returns whether the
+ * frontmatter changed.
+ */
+func (fm *FrontmatterDocument) SetDefault(key string, value string, tag string) bool {
+	if fm.Get(key) != nil {
+		return false
+	}
+
+	return fm.Set(key, value, tag)
+}
+
+/*
+ * List the values of a sequence, or the comma / space separated values
+ * of a scalar
+ */
+func (fm *FrontmatterDocument) GetList(key string) []string {
+	node := fm.Get(key)
+	values := []string{}
+
+	if node == nil {
+		return values
+	}
+
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			if child.Kind == yaml.ScalarNode && child.Value != "" {
+				values = append(values, child.Value)
+			}
+		}
+	case yaml.ScalarNode:
+		for _, value := range strings.FieldsFunc(node.Value, func(char rune) bool {
+			return char == ',' || char == ' '
+		}) {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+/*
+ * Merge values into a list, ignoring values already present (compared
+ * case-insensitively, as Obsidian does for tags). Missing or scalar values
+ * are converted to a flow-style list, but only when a value is added.
+ * Returns whether the frontmatter changed.
+ */
+func (fm *FrontmatterDocument) AddToList(key string, values []string) bool {
+	present := NewSet([]string{})
+	for _, value := range fm.GetList(key) {
+		present.Add(strings.ToLower(value))
+	}
+
+	added := []string{}
+	for _, value := range values {
+		if present.Has(strings.ToLower(value)) {
+			continue
+		}
+
+		present.Add(strings.ToLower(value))
+		added = append(added, value)
+	}
+
+	if len(added) == 0 {
+		return false
+	}
+
+	node := fm.Get(key)
+
+	if node == nil {
+		node = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle}
+		fm.append(key, node)
+	} else if node.Kind != yaml.SequenceNode {
+		existing := fm.GetList(key)
+
+		*node = yaml.Node{
+			Kind:        yaml.SequenceNode,
+			Tag:         "!!seq",
+			Style:       yaml.FlowStyle,
+			HeadComment: node.HeadComment,
+			LineComment: node.LineComment,
+			FootComment: node.FootComment,
+		}
+
+		for _, value := range existing {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+		}
+	}
+
+	for _, value := range added {
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+	}
+
+	return true
+}
+
+/*
+ * Remove values from a list. A scalar holding a removed value is converted
+ * to a flow-style list of the values kept. Returns whether the frontmatter
+ * changed.
+ */
+func (fm *FrontmatterDocument) RemoveFromList(key string, remove func(value string) bool) bool {
+	node := fm.Get(key)
+	if node == nil {
+		return false
+	}
+
+	values := fm.GetList(key)
+	kept := []string{}
+
+	for _, value := range values {
+		if !remove(value) {
+			kept = append(kept, value)
+		}
+	}
+
+	if len(kept) == len(values) {
+		return false
+	}
+
+	if node.Kind == yaml.SequenceNode {
+		content := []*yaml.Node{}
+		for _, child := range node.Content {
+			if child.Kind != yaml.ScalarNode || !remove(child.Value) {
+				content = append(content, child)
+			}
+		}
+
+		node.Content = content
+		return true
+	}
+
+	*node = yaml.Node{
+		Kind:        yaml.SequenceNode,
+		Tag:         "!!seq",
+		Style:       yaml.FlowStyle,
+		HeadComment: node.HeadComment,
+		LineComment: node.LineComment,
+		FootComment: node.FootComment,
+	}
+
+	for _, value := range kept {
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+	}
+
+	return true
+}
+
+/*
+ * Is a tag under one of the update's owned prefixes, but no longer one of
+ * its tags? Tags are compared case-insensitively.
+ */
+func (update *FrontmatterUpdate) staleTag(tag string) bool {
+	for _, current := range update.Tags {
+		if strings.EqualFold(current, tag) {
+			return false
+		}
+	}
+
+	for _, prefix := range update.OwnedPrefix {
+		if prefix != "" && strings.HasPrefix(strings.ToLower(tag), strings.ToLower(prefix)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Merge an update into the frontmatter, returning the keys that changed
+ *
+ */
+func (fm *FrontmatterDocument) Merge(update *FrontmatterUpdate) []string {
+	changed := []string{}
+
+	for _, field := range update.Fields {
+		tag := field.Tag
+		if tag == "" {
+			tag = "!!str"
+		}
+
+		if field.Override && fm.Set(field.Key, field.Value, tag) {
+			changed = append(changed, field.Key)
+		}
+
+		if !field.Override && fm.SetDefault(field.Key, field.Value, tag) {
+			changed = append(changed, field.Key)
+		}
+	}
+
+	removed := len(update.OwnedPrefix) > 0 && fm.RemoveFromList("tags", update.staleTag)
+	added := update.Tags != nil && fm.AddToList("tags", update.Tags)
+
+	if removed || added {
+		changed = append(changed, "tags")
+	}
+
+	keys := []string{}
+	for key := range update.Lists {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if fm.AddToList(key, update.Lists[key]) {
+			changed = append(changed, key)
+		}
+	}
+
+	return changed
+}
+
+/*
+ * Serialise the frontmatter, including `---` delimiters
+ *
+ */
+func (fm *FrontmatterDocument) Bytes() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString("---\n")
+
+	if len(fm.mapping().Content) > 0 {
+		enc := yaml.NewEncoder(buf)
+		enc.SetIndent(2)
+
+		if err := enc.Encode(fm.doc); err != nil {
+			return nil, err
+		}
+
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	buf.WriteString("---\n")
+
+	return buf.Bytes(), nil
+}
+
+/*
+ * Serialise a single top-level key. Head comments are left out, as they
+ * stay in place in the original block.
+ */
+func encodeFrontmatterKey(key *yaml.Node, value *yaml.Node) ([]byte, error) {
+	keyCopy := *key
+	keyCopy.HeadComment = ""
+
+	buf := new(bytes.Buffer)
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+
+	err := enc.Encode(&yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{&keyCopy, value}})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+/*
+ * The number of lines in a comment
+ *
+ */
+func commentLines(comment string) int {
+	if comment == "" {
+		return 0
+	}
+
+	return strings.Count(comment, "\n") + 1
+}
+
+/*
+ * Re-serialise only the changed keys of the block the document was parsed
+ * from, leaving every other line byte for byte. Returns false when the block
+ * cannot be patched, such as for flow-style mappings.
+ */
+func (fm *FrontmatterDocument) patch(block []byte, keys []string) ([]byte, bool) {
+	mapping := fm.mapping()
+	lines := bytes.SplitAfter(block, []byte("\n"))
+
+	if len(block) == 0 || mapping.Style&yaml.FlowStyle != 0 {
+		return nil, false
+	}
+
+	closing := -1
+	for idx := len(lines) - 1; idx > 0; idx-- {
+		trimmed := string(bytes.TrimRight(lines[idx], "\r\n"))
+
+		if trimmed == "---" || trimmed == "..." {
+			closing = idx
+			break
+		}
+	}
+
+	if closing < 0 {
+		return nil, false
+	}
+
+	changed := NewSet(keys)
+	replacements := map[int][]byte{}
+	ends := map[int]int{}
+	added := []byte{}
+
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		key, value := mapping.Content[idx], mapping.Content[idx+1]
+		if !changed.Has(key.Value) {
+			continue
+		}
+
+		encoded, err := encodeFrontmatterKey(key, value)
+		if err != nil {
+			return nil, false
+		}
+
+		if key.Line == 0 {
+			added = append(added, encoded...)
+			continue
+		}
+
+		// an existing key spans to the next key's comments, less trailing blank lines;
+		// added keys are appended, so follow every existing key
+		end := closing
+		if idx+2 < len(mapping.Content) && mapping.Content[idx+2].Line > 0 {
+			next := mapping.Content[idx+2]
+			end = next.Line - commentLines(next.HeadComment)
+		}
+
+		for end > key.Line+1 && len(bytes.TrimSpace(lines[end-1])) == 0 {
+			end--
+		}
+
+		if key.Line >= end || end > closing {
+			return nil, false
+		}
+
+		replacements[key.Line] = encoded
+		ends[key.Line] = end
+	}
+
+	patched := []byte{}
+	for idx := 0; idx < len(lines); idx++ {
+		if idx == closing {
+			patched = append(patched, added...)
+		}
+
+		if encoded, ok := replacements[idx]; ok {
+			patched = append(patched, encoded...)
+			idx = ends[idx] - 1
+			continue
+		}
+
+		patched = append(patched, lines[idx]...)
+	}
+
+	return patched, true
+}
+
+/*
+ * Merge an update into a note's content. Only the changed frontmatter keys
+ * are re-serialised; other lines and the body are preserved byte for byte.
+ * Notes without frontmatter gain a block.
+ */
+func MergeFrontmatter(content []byte, update *FrontmatterUpdate) ([]byte, []string, error) {
+	block, body := SplitFrontmatter(content)
+
+	fm, err := ParseFrontmatterDocument(block)
+	if err != nil {
+		return content, []string{}, err
+	}
+
+	changed := fm.Merge(update)
+	if len(changed) == 0 {
+		return content, changed, nil
+	}
+
+	// the patched block must still parse; otherwise re-serialise it all
+	if header, ok := fm.patch(block, changed); ok {
+		if _, err := ParseFrontmatterDocument(header); err == nil {
+			return append(header, body...), changed, nil
+		}
+	}
+
+	header, err := fm.Bytes()
+	if err != nil {
+		return content, changed, err
+	}
+
+	return append(header, body...), changed, nil
+}
@@ -0,0 +1,84 @@
+package opal
+
+import (
+	"testing"
+)
+
+func TestMergeFrontmatterLeavesUnchangedNotesAlone(t *testing.T) {
+	content := "---\ntitle: Hello\ntags: foo\naliases:\n- a\n- b\n---\n# Hello\n"
+	note := NewObsidianNote("/vault", "/vault/20220101 - Hello.md")
+
+	update := note.ComputedFrontmatter()
+	update.Tags = []string{"FOO"}
+
+	merged, changed, err := MergeFrontmatter([]byte(content), update)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changed) != 1 || changed[0] != "created" {
+		t.Fatalf("expected only created to change, got %v", changed)
+	}
+
+	expected := "---\ntitle: Hello\ntags: foo\naliases:\n- a\n- b\ncreated: 2022-01-01\n---\n# Hello\n"
+	if string(merged) != expected {
+		t.Errorf("unexpected frontmatter:\n%s", merged)
+	}
+}
+
+func TestMergeFrontmatterDoesNotAddEmptyTags(t *testing.T) {
+	note := NewObsidianNote("/vault", "/vault/20220101 - Hello.md")
+
+	merged, _, err := MergeFrontmatter([]byte("# Hello\n"), note.ComputedFrontmatter())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(merged) != "---\ntitle: Hello\ncreated: 2022-01-01\n---\n# Hello\n" {
+		t.Errorf("unexpected frontmatter:\n%s", merged)
+	}
+}
+
+func TestMergeFrontmatterPatchesOnlyChangedKeys(t *testing.T) {
+	content := "---\n# about the note\ntitle: Old # stale\n\n# tags follow\ntags:\n- a\nsummary: |\n  line one\n  line two\n---\nbody\n"
+
+	merged, changed, err := MergeFrontmatter([]byte(content), &FrontmatterUpdate{
+		Fields: []*FrontmatterField{{Key: "title", Value: "New", Override: true}},
+		Tags:   []string{"b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changed) != 2 {
+		t.Fatalf("expected title and tags to change, got %v", changed)
+	}
+
+	expected := "---\n# about the note\ntitle: New # stale\n\n# tags follow\ntags:\n  - a\n  - b\nsummary: |\n  line one\n  line two\n---\nbody\n"
+	if string(merged) != expected {
+		t.Errorf("unexpected frontmatter:\n%s", merged)
+	}
+}
+
+func TestAddToListConvertsScalarsOnlyWhenAdding(t *testing.T) {
+	fm, err := ParseFrontmatterDocument([]byte("---\ntags: foo, bar\n---\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fm.AddToList("tags", []string{"Foo"}) {
+		t.Error("expected no change adding a present tag")
+	}
+
+	if fm.AddToList("aliases", []string{}) || fm.Get("aliases") != nil {
+		t.Error("expected no list to be created without values")
+	}
+
+	if !fm.AddToList("tags", []string{"baz"}) {
+		t.Fatal("expected a change adding a new tag")
+	}
+
+	if tags := fm.GetList("tags"); len(tags) != 3 || tags[2] != "baz" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+}
@@ -0,0 +1,229 @@
+package opal
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * Date layouts accepted by template date functions
+ */
+var templateDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"20060102",
+}
+
+/*
+ * The functions available to every template. Functions take the piped
+ * value as their final argument, so they compose in pipelines:
+ * `{{ .Time | FormatDate "2006-01-02" }}`
+ */
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"TitleCase":     TitleCase,
+		"Yaml":          YamlQuote,
+		"Slugify":       Slugify,
+		"ParseDate":     ParseDate,
+		"FormatDate":    FormatDate,
+		"Host":          UrlHost,
+		"Tag":           NormaliseTag,
+		"Wikilink":      Wikilink,
+		"WikilinkAlias": WikilinkAlias,
+		"Join":          Join,
+		"Split":         Split,
+		"List":          SplitList,
+		"Truncate":      Truncate,
+	}
+}
+
+/*
+ * Quote a value as a double-quoted YAML scalar. Slices become flow
+ * sequences of quoted scalars.
+ */
+func YamlQuote(value interface{}) string {
+	switch value := value.(type) {
+	case []string:
+		quoted := make([]string, len(value))
+		for idx, elem := range value {
+			quoted[idx] = YamlQuote(elem)
+		}
+
+		return "[" + strings.Join(quoted, ", ") + "]"
+	case string:
+		return quoteYamlString(value)
+	default:
+		return quoteYamlString(fmt.Sprint(value))
+	}
+}
+
+/*
+ * Escape a string as a YAML double-quoted scalar, on a single line.
+ * Printable unicode is kept as-is; everything else is escaped.
+ */
+func quoteYamlString(value string) string {
+	buf := strings.Builder{}
+	buf.WriteString(`"`)
+
+	for _, char := range value {
+		switch char {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case 0:
+			buf.WriteString(`\0`)
+		case 0x85:
+			buf.WriteString(`\N`)
+		case 0xA0:
+			buf.WriteString(`\_`)
+		case 0x2028:
+			buf.WriteString(`\L`)
+		case 0x2029:
+			buf.WriteString(`\P`)
+		default:
+			if !unicode.IsPrint(char) && char != ' ' {
+				if char <= 0xFFFF {
+					buf.WriteString(fmt.Sprintf(`\u%04X`, char))
+				} else {
+					buf.WriteString(fmt.Sprintf(`\U%08X`, char))
+				}
+			} else {
+				buf.WriteRune(char)
+			}
+		}
+	}
+
+	buf.WriteString(`"`)
+	return buf.String()
+}
+
+var slugChars = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+/*
+ * Convert a string to a lowercase, dash-separated slug
+ *
+ */
+func Slugify(value string) string {
+	return strings.Trim(slugChars.ReplaceAllString(strings.ToLower(value), "-"), "-")
+}
+
+/*
+ * Parse a date in any of the common layouts used by Pinboard and GitHub
+ *
+ */
+func ParseDate(value string) (time.Time, error) {
+	for _, layout := range templateDateLayouts {
+		if date, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, errors.New("could not parse date '" + value + "'")
+}
+
+/*
+ * Reformat a date string using a Go time layout. Empty dates are
+ * left empty.
+ */
+func FormatDate(layout string, value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return "", nil
+	}
+
+	date, err := ParseDate(value)
+	if err != nil {
+		return "", err
+	}
+
+	return date.Format(layout), nil
+}
+
+/*
+ * Extract the host-name from a URL
+ *
+ */
+func UrlHost(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return ""
+	}
+
+	return parsed.Hostname()
+}
+
+/*
+ * Create an Obsidian wikilink
+ *
+ */
+func Wikilink(target string) string {
+	return "[[" + strings.NewReplacer("[", "", "]", "", "|", "-", "#", "-", "^", "-").Replace(target) + "]]"
+}
+
+/*
+ * Create an Obsidian wikilink with an alias. The target is the last
+ * argument, so it can be piped: `{{ .Name | WikilinkAlias "alias" }}`
+ */
+func WikilinkAlias(alias string, target string) string {
+	link := Wikilink(target)
+	alias = strings.NewReplacer("[", "", "]", "").Replace(alias)
+
+	if alias == "" || alias == link[2:len(link)-2] {
+		return link
+	}
+
+	return link[:len(link)-2] + "|" + alias + "]]"
+}
+
+/*
+ * Join a list with a separator
+ *
+ */
+func Join(sep string, values []string) string {
+	return strings.Join(values, sep)
+}
+
+/*
+ * Split a string on a separator, dropping empty values
+ *
+ */
+func Split(sep string, value string) []string {
+	values := []string{}
+
+	for _, part := range strings.Split(value, sep) {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+
+	return values
+}
+
+/*
+ * Truncate a string to at most a number of characters
+ *
+ */
+func Truncate(limit int, value string) string {
+	runes := []rune(value)
+
+	if len(runes) <= limit {
+		return value
+	}
+
+	return strings.TrimSpace(string(runes[0:limit]))
+}
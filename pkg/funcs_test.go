@@ -0,0 +1,29 @@
+package opal
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+)
+
+func TestWikilinkFuncs(t *testing.T) {
+	cases := map[string]string{
+		`{{ .Name | Wikilink }}`:                  "[[Opal - Notes]]",
+		`{{ .Name | WikilinkAlias "opal" }}`:      "[[Opal - Notes|opal]]",
+		`{{ WikilinkAlias "" .Name }}`:            "[[Opal - Notes]]",
+		`{{ "a|b#c" | WikilinkAlias "[alias]" }}`: "[[a-b-c|alias]]",
+	}
+
+	for text, expected := range cases {
+		tmpl := template.Must(template.New("test").Funcs(TemplateFuncs()).Parse(text))
+
+		buf := new(bytes.Buffer)
+		if err := tmpl.Execute(buf, map[string]string{"Name": "Opal - Notes"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.String() != expected {
+			t.Errorf("%s rendered %q, expected %q", text, buf.String(), expected)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package opal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	CheckOrphanNotes = "orphan-notes"
+	CheckDeadEnds    = "dead-ends"
+	CheckAttachments = "attachments"
+)
+
+/*
+ * A parsed link, and the files it resolves to
+ */
+type ResolvedLink struct {
+	Link    *Link
+	Matches []string
+}
+
+/*
+ * The vault's notes and attachments, and the links between them
+ */
+type LinkGraph struct {
+	Notes       []string
+	Attachments []string
+	Links       map[string][]*ResolvedLink
+	Inbound     map[string]*Set
+	Outbound    map[string]*Set
+	resolver    *LinkResolver
+}
+
+/*
+ * Is a vault file an attachment? Canvases and hidden files, such as Opal's
+ * own database, are not.
+ */
+func isAttachment(relpath string) bool {
+	ext := filepath.Ext(relpath)
+	if ext == ".md" || ext == ".canvas" {
+		return false
+	}
+
+	for _, segment := range strings.Split(filepath.ToSlash(relpath), "/") {
+		if strings.HasPrefix(segment, ".") {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+ * Parse and resolve every link in the vault. Links to a note from itself
+ * don't count as inbound or outbound links.
+ */
+func (vault *ObsidianVault) NewLinkGraph() (*LinkGraph, error) {
+	resolver, err := vault.NewLinkResolver()
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &LinkGraph{
+		Notes:       []string{},
+		Attachments: []string{},
+		Links:       map[string][]*ResolvedLink{},
+		Inbound:     map[string]*Set{},
+		Outbound:    map[string]*Set{},
+		resolver:    resolver,
+	}
+
+	fpaths, err := vault.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fpath := range fpaths {
+		graph.Inbound[fpath] = NewSet([]string{})
+		graph.Outbound[fpath] = NewSet([]string{})
+
+		if filepath.Ext(fpath) == ".md" {
+			graph.Notes = append(graph.Notes, fpath)
+		} else if isAttachment(vault.RelativePath(fpath)) {
+			graph.Attachments = append(graph.Attachments, fpath)
+		}
+	}
+
+	for _, fpath := range graph.Notes {
+		content, err := os.ReadFile(fpath)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, link := range ParseLinks(content) {
+			matches := resolver.Resolve(fpath, link)
+			graph.Links[fpath] = append(graph.Links[fpath], &ResolvedLink{Link: link, Matches: matches})
+
+			for _, target := range matches {
+				if target == fpath {
+					continue
+				}
+
+				graph.Outbound[fpath].Add(target)
+				graph.Inbound[target].Add(fpath)
+			}
+		}
+	}
+
+	return graph, nil
+}
+
+/*
+ * Is a file beneath one of the excluded vault folders?
+ *
+ */
+func (vault *ObsidianVault) InFolders(fpath string, folders []string) bool {
+	relpath := filepath.ToSlash(vault.RelativePath(fpath))
+
+	for _, folder := range folders {
+		folder = strings.Trim(filepath.ToSlash(folder), "/")
+
+		if folder != "" && (relpath == folder || strings.HasPrefix(relpath, folder+"/")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Report notes with no links in or out, which nobody will find again.
+ * Notes in excluded folders, such as generated bookmarks, are not
+ * reported, but their links still count.
+ */
+func CheckNoOrphanNotes(vault *ObsidianVault, graph *LinkGraph, exclude []string, report *ValidationReport) {
+	for _, fpath := range graph.Notes {
+		if vault.InFolders(fpath, exclude) {
+			continue
+		}
+
+		if graph.Inbound[fpath].Size() == 0 && graph.Outbound[fpath].Size() == 0 {
+			report.Add(CheckOrphanNotes, SeverityWarning, fpath, "no notes link to or from this note")
+		}
+	}
+}
+
+/*
+ * Report linked notes that link nowhere else
+ *
+ */
+func CheckNoDeadEnds(vault *ObsidianVault, graph *LinkGraph, exclude []string, report *ValidationReport) {
+	for _, fpath := range graph.Notes {
+		if vault.InFolders(fpath, exclude) {
+			continue
+		}
+
+		if graph.Inbound[fpath].Size() > 0 && graph.Outbound[fpath].Size() == 0 {
+			report.Add(CheckDeadEnds, SeverityWarning, fpath, "note has no outbound links")
+		}
+	}
+}
+
+/*
+ * Report attachments no note embeds or links to
+ *
+ */
+func CheckNoUnreferencedAttachments(vault *ObsidianVault, graph *LinkGraph, exclude []string, report *ValidationReport) {
+	for _, fpath := range graph.Attachments {
+		if vault.InFolders(fpath, exclude) {
+			continue
+		}
+
+		if graph.Inbound[fpath].Size() == 0 {
+			report.Add(CheckAttachments, SeverityWarning, fpath, "attachment is not referenced by any note")
+		}
+	}
+}
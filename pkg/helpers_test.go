@@ -0,0 +1,73 @@
+package opal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+/*
+ * Create a temporary vault holding the given files, keyed by relative path
+ *
+ */
+func newTestVault(t *testing.T, files map[string]string) *ObsidianVault {
+	t.Helper()
+	dir := t.TempDir()
+
+	for relpath, content := range files {
+		writeTestFile(t, filepath.Join(dir, relpath), content)
+	}
+
+	return NewObsidianVault(dir, NewPlan(false, dir))
+}
+
+/*
+ * Write a file, creating its parent directories
+ *
+ */
+func writeTestFile(t *testing.T, fpath string, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(fpath), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(fpath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/*
+ * Read a file from a vault, failing the test if it is missing
+ *
+ */
+func readTestFile(t *testing.T, vault *ObsidianVault, relpath string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(filepath.Join(vault.dpath, relpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(content)
+}
+
+/*
+ * Change into a directory for the rest of the test
+ *
+ */
+func chdirTest(t *testing.T, dir string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
@@ -0,0 +1,176 @@
+package opal
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+)
+
+/*
+ * Directories never traversed when listing vault files. `.opal` holds
+ * Opal's configuration, templates and database.
+ */
+var IgnoredDirectories = []string{".obsidian", ".trash", ".git", ".opal"}
+
+/*
+ * A single gitignore-style pattern
+ */
+type IgnoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+/*
+ * An ordered list of gitignore-style patterns; the last matching
+ * pattern decides whether a path is ignored
+ */
+type IgnoreRules struct {
+	rules []*IgnoreRule
+}
+
+/*
+ * Load ignore rules from a file. A missing file has no rules.
+ *
+ */
+func LoadIgnoreRules(fpath string) (*IgnoreRules, error) {
+	content, err := os.ReadFile(fpath)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return &IgnoreRules{}, nil
+	}
+
+	if err != nil {
+		return &IgnoreRules{}, err
+	}
+
+	return ParseIgnoreRules(string(content))
+}
+
+/*
+ * Parse gitignore-style patterns, one per line. Supports comments, `!`
+ * negation, trailing `/` for directories, leading `/` anchors, `*`, `?`,
+ * character classes and `**`.
+ */
+func ParseIgnoreRules(content string) (*IgnoreRules, error) {
+	rules := &IgnoreRules{}
+
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		// trailing spaces are ignored unless escaped
+		if !strings.HasSuffix(line, "\\ ") {
+			line = strings.TrimRight(line, " ")
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := &IgnoreRule{}
+
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+			line = line[1:]
+		}
+
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+
+		// patterns containing a slash are relative to the vault root;
+		// others match at any depth
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+
+		expr := globToRegexp(line)
+		if anchored {
+			expr = "^" + expr + "$"
+		} else {
+			expr = "^(?:.*/)?" + expr + "$"
+		}
+
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return rules, err
+		}
+
+		rule.pattern = pattern
+		rules.rules = append(rules.rules, rule)
+	}
+
+	return rules, nil
+}
+
+/*
+ * Convert a gitignore glob into an unanchored regular expression
+ *
+ */
+func globToRegexp(glob string) string {
+	expr := strings.Builder{}
+
+	for idx := 0; idx < len(glob); idx++ {
+		char := glob[idx]
+
+		switch {
+		case strings.HasPrefix(glob[idx:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			idx += 2
+		case strings.HasPrefix(glob[idx:], "/**") && idx+3 == len(glob):
+			expr.WriteString("/.*")
+			idx += 2
+		case strings.HasPrefix(glob[idx:], "**"):
+			expr.WriteString(".*")
+			idx += 1
+		case char == '*':
+			expr.WriteString("[^/]*")
+		case char == '?':
+			expr.WriteString("[^/]")
+		case char == '\\' && idx+1 < len(glob):
+			idx++
+			expr.WriteString(regexp.QuoteMeta(string(glob[idx])))
+		case char == '[':
+			end := strings.Index(glob[idx+1:], "]")
+			if end < 0 {
+				expr.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+
+			class := glob[idx+1 : idx+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			expr.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			idx += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+
+	return expr.String()
+}
+
+/*
+ * Is a slash-separated path, relative to the vault root, ignored?
+ *
+ */
+func (rules *IgnoreRules) Ignored(relpath string, isDir bool) bool {
+	ignored := false
+
+	for _, rule := range rules.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		if rule.pattern.MatchString(relpath) {
+			ignored = !rule.negate
+		}
+	}
+
+	return ignored
+}
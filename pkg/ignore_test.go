@@ -0,0 +1,63 @@
+package opal
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules, err := ParseIgnoreRules("# comment\n*.tmp\n/private/\ndrafts/**\n!drafts/keep.md\n**/cache\nnote?.md\n[ab].md\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		relpath string
+		isDir   bool
+		ignored bool
+	}{
+		{"scratch.tmp", false, true},
+		{"a/b/scratch.tmp", false, true},
+		{"private", true, true},
+		{"private", false, false},
+		{"notes/private", true, false},
+		{"drafts/idea.md", false, true},
+		{"drafts/keep.md", false, false},
+		{"a/b/cache", true, true},
+		{"note1.md", false, true},
+		{"note10.md", false, false},
+		{"a.md", false, true},
+		{"c.md", false, false},
+		{"# comment", false, false},
+	}
+
+	for _, testCase := range cases {
+		if actual := rules.Ignored(testCase.relpath, testCase.isDir); actual != testCase.ignored {
+			t.Errorf("Ignored(%q, %v) = %v, expected %v", testCase.relpath, testCase.isDir, actual, testCase.ignored)
+		}
+	}
+}
+
+func TestListFilesHonoursIgnoreRules(t *testing.T) {
+	vault := newTestVault(t, map[string]string{
+		".opalignore":          "drafts/\n",
+		"Note.md":              "",
+		"drafts/Draft.md":      "",
+		".obsidian/config.md":  "",
+		"nested/deep/Other.md": "",
+	})
+
+	fpaths, err := vault.ListMarkdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed := NewSet([]string{})
+	for _, fpath := range fpaths {
+		listed.Add(filepath.ToSlash(vault.RelativePath(fpath)))
+	}
+
+	if listed.Size() != 2 || !listed.Has("Note.md") || !listed.Has("nested/deep/Other.md") {
+		t.Errorf("unexpected notes listed: %v", fpaths)
+	}
+}
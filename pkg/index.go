@@ -0,0 +1,152 @@
+package opal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	diatom "github.com/rgrannell1/diatom/pkg"
+)
+
+const (
+	IndexerDiatom  = "diatom"
+	IndexerBuiltin = "builtin"
+)
+
+/*
+ * Indexes a vault's notes into the database's `file` and `metadata` tables,
+ * which Opal reads note hashes and frontmatter from
+ */
+type Indexer interface {
+	Index(dir string, dbpath string) error
+}
+
+/*
+ * Index the vault with Diatom
+ *
+ */
+type DiatomIndexer struct{}
+
+func (indexer *DiatomIndexer) Index(dir string, dbpath string) error {
+	return diatom.Diatom(&diatom.DiatomArgs{
+		Dir:    dir,
+		DBPath: dbpath,
+	})
+}
+
+/*
+ * A lightweight indexer recording each note's hash and frontmatter, for
+ * tests and for users without Diatom. The vault's rows are rebuilt on each
+ * run; rows for other vaults sharing the database are left alone.
+ */
+type BuiltinIndexer struct{}
+
+func (indexer *BuiltinIndexer) Index(dir string, dbpath string) error {
+	// ids are absolute, so they always share the vault's prefix
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	conn, err := NewOpalDb(dbpath)
+	if err != nil {
+		return err
+	}
+	defer conn.Db.Close()
+
+	fpaths, err := NewObsidianVault(dir, nil).ListMarkdown()
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS file (
+			id      TEXT NOT NULL,
+			hash    TEXT NOT NULL,
+
+			PRIMARY KEY(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS metadata (
+			file_id    TEXT NOT NULL,
+			schema     TEXT NOT NULL,
+			content    TEXT NOT NULL
+		)`,
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return errors.Wrap(err, "failed preparing index tables")
+		}
+	}
+
+	// only this vault's rows; LIKE would treat `_` and `%` in paths as wildcards
+	prefix := VaultPrefix(dir)
+	statements = []string{
+		`DELETE FROM metadata WHERE substr(file_id, 1, length(?1)) = ?1`,
+		`DELETE FROM file WHERE substr(id, 1, length(?1)) = ?1`,
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, prefix); err != nil {
+			return errors.Wrap(err, "failed clearing the vault's index")
+		}
+	}
+
+	for _, fpath := range fpaths {
+		content, err := os.ReadFile(fpath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// empty files have a zero hash, as in diatom
+		hash := "0"
+		if len(content) > 0 {
+			sum := sha256.Sum256(content)
+			hash = hex.EncodeToString(sum[:])
+		}
+
+		if _, err := tx.Exec(`INSERT INTO file (id, hash) VALUES (?, ?)`, fpath, hash); err != nil {
+			return err
+		}
+
+		block, _ := SplitFrontmatter(content)
+		if len(block) == 0 {
+			continue
+		}
+
+		_, err = tx.Exec(`INSERT INTO metadata (file_id, schema, content) VALUES (?, '!frontmatter', ?)`,
+			fpath, string(StripFrontmatterDelimiters(block)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+/*
+ * Construct an indexer by name; Diatom is the default
+ *
+ */
+func NewIndexer(name string) (Indexer, error) {
+	switch name {
+	case "", IndexerDiatom:
+		return &DiatomIndexer{}, nil
+	case IndexerBuiltin:
+		return &BuiltinIndexer{}, nil
+	default:
+		return nil, errors.New("unknown indexer '" + name + "'; expected diatom or builtin")
+	}
+}
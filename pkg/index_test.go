@@ -0,0 +1,56 @@
+package opal
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBuiltinIndexerKeepsOtherVaults(t *testing.T) {
+	conn, dbpath := newTestDb(t)
+	first := newTestVault(t, map[string]string{"20220101 - First.md": "---\ntitle: First\n---\n"})
+	second := newTestVault(t, map[string]string{"20220101 - Second.md": "# Second\n"})
+
+	indexer := &BuiltinIndexer{}
+	for _, vault := range []*ObsidianVault{first, second, first} {
+		if err := indexer.Index(vault.dpath, dbpath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// each vault sees only its own rows
+	for vault, fname := range map[*ObsidianVault]string{first: "20220101 - First.md", second: "20220101 - Second.md"} {
+		hashes, err := conn.ListHashes(vault.dpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := filepath.Join(vault.dpath, fname)
+		if len(hashes) != 1 || hashes[0][0] != expected {
+			t.Errorf("expected only %s to be indexed, got %v", expected, hashes)
+		}
+	}
+}
+
+func TestBuiltinIndexerRelativeVault(t *testing.T) {
+	conn, dbpath := newTestDb(t)
+	vault := newTestVault(t, map[string]string{"20220101 - Note.md": "---\ntitle: Note\n---\n"})
+	chdirTest(t, vault.dpath)
+
+	// re-indexing must clear the rows written by the first run
+	indexer := &BuiltinIndexer{}
+	for run := 0; run < 2; run++ {
+		if err := indexer.Index(".", dbpath); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+	}
+
+	hashes, err := conn.ListHashes(vault.dpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := filepath.Join(vault.dpath, "20220101 - Note.md")
+	if len(hashes) != 1 || hashes[0][0] != expected {
+		t.Fatalf("expected only %s to be indexed, got %v", expected, hashes)
+	}
+}
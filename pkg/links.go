@@ -0,0 +1,330 @@
+package opal
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const CheckLinks = "links"
+
+/*
+ * A link from one note to another note, heading, block or attachment
+ */
+type Link struct {
+	Raw      string
+	Target   string
+	Heading  string
+	Block    string
+	Embed    bool
+	Markdown bool
+	Line     int
+	// byte offset of the link within its line
+	Column int
+}
+
+var wikilinkPattern = regexp.MustCompile(`(!?)\[\[([^\[\]]+?)\]\]`)
+var markdownLinkPattern = regexp.MustCompile(`(!?)\[[^\]]*\]\((?:<([^>]+)>|([^()\s]+))(?:\s+"[^"]*")?\)`)
+var inlineCodePattern = regexp.MustCompile("`+[^`]*`+")
+var urlSchemePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+var blockIdPattern = regexp.MustCompile(`(?:^|\s)\^([A-Za-z0-9-]+)\s*$`)
+
+/*
+ * Split a link target into its path, heading and block. Nested headings
+ * (`note#h1#h2`) refer to the last heading.
+ */
+func splitLinkTarget(link *Link, target string) {
+	parts := strings.Split(target, "#")
+	link.Target = strings.TrimSpace(parts[0])
+
+	if len(parts) < 2 {
+		return
+	}
+
+	last := strings.TrimSpace(parts[len(parts)-1])
+	if strings.HasPrefix(last, "^") {
+		link.Block = last[1:]
+	} else {
+		link.Heading = last
+	}
+}
+
+/*
+ * The submatches of a line at the given indices; unmatched groups are empty
+ *
+ */
+func submatches(line string, loc []int) []string {
+	match := make([]string, len(loc)/2)
+
+	for idx := range match {
+		if loc[2*idx] >= 0 {
+			match[idx] = line[loc[2*idx]:loc[2*idx+1]]
+		}
+	}
+
+	return match
+}
+
+/*
+ * Parse wikilinks, embeds and relative markdown links from a note. Links in
+ * frontmatter, fenced code-blocks and inline code are ignored, as are
+ * external URLs.
+ */
+func ParseLinks(content []byte) []*Link {
+	links := []*Link{}
+	block, body := SplitFrontmatter(content)
+	offset := strings.Count(string(block), "\n")
+	fence := ""
+
+	for idx, line := range strings.Split(string(body), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[0:3]
+			continue
+		}
+
+		// blank inline code, keeping the offsets of the links around it
+		code := inlineCodePattern.ReplaceAllStringFunc(line, func(match string) string {
+			return strings.Repeat(" ", len(match))
+		})
+
+		for _, loc := range wikilinkPattern.FindAllStringSubmatchIndex(code, -1) {
+			match := submatches(line, loc)
+			link := &Link{Raw: match[0], Embed: match[1] == "!", Line: offset + idx + 1, Column: loc[0]}
+			// pipes are escaped inside tables
+			target := strings.TrimSuffix(strings.SplitN(match[2], "|", 2)[0], "\\")
+			splitLinkTarget(link, target)
+			links = append(links, link)
+		}
+
+		for _, loc := range markdownLinkPattern.FindAllStringSubmatchIndex(code, -1) {
+			match := submatches(line, loc)
+			target := match[2] + match[3]
+			if urlSchemePattern.MatchString(target) {
+				continue
+			}
+
+			if decoded, err := url.PathUnescape(target); err == nil {
+				target = decoded
+			}
+
+			link := &Link{Raw: match[0], Embed: match[1] == "!", Markdown: true, Line: offset + idx + 1, Column: loc[0]}
+			splitLinkTarget(link, target)
+			links = append(links, link)
+		}
+	}
+
+	return links
+}
+
+/*
+ * Normalise heading text for comparison, as Obsidian does; characters
+ * that cannot appear in links are dropped, and case and spacing ignored
+ */
+func normaliseHeading(heading string) string {
+	heading = strings.NewReplacer("[[", " ", "]]", " ", "|", " ", "^", " ", ":", " ", "%%", " ", "#", " ").Replace(heading)
+	return strings.ToLower(strings.Join(strings.Fields(heading), " "))
+}
+
+/*
+ * The normalised headings and block IDs in a note
+ *
+ */
+func ParseAnchors(content []byte) (*Set, *Set) {
+	headings := NewSet([]string{})
+	blocks := NewSet([]string{})
+	_, body := SplitFrontmatter(content)
+	fence := ""
+
+	for _, line := range strings.Split(string(body), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[0:3]
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "#") {
+			text := strings.TrimLeft(trimmed, "#")
+			if text == "" || text[0] == ' ' || text[0] == '\t' {
+				headings.Add(normaliseHeading(strings.TrimRight(text, "# \t")))
+				continue
+			}
+		}
+
+		if match := blockIdPattern.FindStringSubmatch(trimmed); match != nil {
+			blocks.Add(strings.ToLower(match[1]))
+		}
+	}
+
+	return headings, blocks
+}
+
+/*
+ * Resolves link targets to vault files the way Obsidian does: by path from
+ * the vault root or the linking note, or by the shortest unique path suffix,
+ * case-insensitively. Notes may be linked without their `.md` extension.
+ */
+type LinkResolver struct {
+	vault *ObsidianVault
+	files map[string]string
+	// lower-cased relative paths by basename, for suffix matches
+	names   map[string][]string
+	anchors map[string][2]*Set
+}
+
+/*
+ * Index every file in the vault
+ *
+ */
+func (vault *ObsidianVault) NewLinkResolver() (*LinkResolver, error) {
+	resolver := &LinkResolver{
+		vault:   vault,
+		files:   map[string]string{},
+		names:   map[string][]string{},
+		anchors: map[string][2]*Set{},
+	}
+
+	fpaths, err := vault.ListFiles()
+	if err != nil {
+		return resolver, err
+	}
+
+	for _, fpath := range fpaths {
+		relpath := strings.ToLower(filepath.ToSlash(vault.RelativePath(fpath)))
+		resolver.files[relpath] = fpath
+		resolver.names[path.Base(relpath)] = append(resolver.names[path.Base(relpath)], relpath)
+	}
+
+	return resolver, nil
+}
+
+/*
+ * Resolve a link from a note to the vault files it may refer to. More than
+ * one file is returned for ambiguous links.
+ */
+func (resolver *LinkResolver) Resolve(source string, link *Link) []string {
+	target := strings.ToLower(filepath.ToSlash(link.Target))
+	if target == "" {
+		return []string{source}
+	}
+
+	sourceDir := path.Dir(strings.ToLower(filepath.ToSlash(resolver.vault.RelativePath(source))))
+
+	candidates := []string{target}
+	if path.Ext(target) != ".md" {
+		candidates = append(candidates, target+".md")
+	}
+
+	// exact paths, from the vault root or relative to the linking note
+	for _, candidate := range candidates {
+		for _, relpath := range []string{path.Clean(strings.TrimPrefix(candidate, "/")), path.Join(sourceDir, candidate)} {
+			if fpath, ok := resolver.files[relpath]; ok {
+				return []string{fpath}
+			}
+		}
+	}
+
+	// markdown links are always paths
+	if link.Markdown {
+		return []string{}
+	}
+
+	matches := []string{}
+	for _, candidate := range candidates {
+		for _, relpath := range resolver.names[path.Base(candidate)] {
+			if strings.HasSuffix(relpath, "/"+candidate) {
+				matches = append(matches, resolver.files[relpath])
+			}
+		}
+
+		if len(matches) > 0 {
+			break
+		}
+	}
+
+	return matches
+}
+
+/*
+ * The headings and block IDs of a note, read once
+ *
+ */
+func (resolver *LinkResolver) Anchors(fpath string) (*Set, *Set, error) {
+	if anchors, ok := resolver.anchors[fpath]; ok {
+		return anchors[0], anchors[1], nil
+	}
+
+	content, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headings, blocks := ParseAnchors(content)
+	resolver.anchors[fpath] = [2]*Set{headings, blocks}
+
+	return headings, blocks, nil
+}
+
+/*
+ * Check every link in every note resolves to a file, and that linked
+ * headings and block IDs exist. Links to missing files are errors; missing
+ * headings or blocks, and ambiguous links, are warnings.
+ */
+func CheckNoBrokenLinks(graph *LinkGraph, report *ValidationReport) error {
+	for _, fpath := range graph.Notes {
+		for _, resolved := range graph.Links[fpath] {
+			link := resolved.Link
+			matches := resolved.Matches
+			prefix := fmt.Sprintf("line %d: %s", link.Line, link.Raw)
+
+			if len(matches) == 0 {
+				report.Add(CheckLinks, SeverityError, fpath, prefix+" links to a missing file")
+				continue
+			}
+
+			if len(matches) > 1 {
+				report.Add(CheckLinks, SeverityWarning, fpath, prefix+fmt.Sprintf(" is ambiguous; %d files match", len(matches)))
+				continue
+			}
+
+			if (link.Heading == "" && link.Block == "") || filepath.Ext(matches[0]) != ".md" {
+				continue
+			}
+
+			headings, blocks, err := graph.resolver.Anchors(matches[0])
+			if err != nil {
+				return err
+			}
+
+			if link.Heading != "" && !headings.Has(normaliseHeading(link.Heading)) {
+				report.Add(CheckLinks, SeverityWarning, fpath, prefix+" links to a missing heading")
+			}
+
+			if link.Block != "" && !blocks.Has(strings.ToLower(link.Block)) {
+				report.Add(CheckLinks, SeverityWarning, fpath, prefix+" links to a missing block")
+			}
+		}
+	}
+
+	return nil
+}
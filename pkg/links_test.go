@@ -0,0 +1,102 @@
+package opal
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkResolverResolve(t *testing.T) {
+	vault := newTestVault(t, map[string]string{
+		"Home.md":         "",
+		"a/Note.md":       "",
+		"b/Note.md":       "",
+		"a/deep/Only.md":  "",
+		"a/image.png":     "",
+		"Other/Readme.md": "",
+	})
+
+	resolver, err := vault.NewLinkResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := filepath.Join(vault.dpath, "a", "Index.md")
+	cases := []struct {
+		link     *Link
+		expected []string
+	}{
+		{&Link{Target: "home"}, []string{"Home.md"}},
+		{&Link{Target: "Note"}, []string{"a/Note.md"}},
+		{&Link{Target: "b/Note"}, []string{"b/Note.md"}},
+		{&Link{Target: "Only"}, []string{"a/deep/Only.md"}},
+		{&Link{Target: "deep/Only.md"}, []string{"a/deep/Only.md"}},
+		{&Link{Target: "image.png"}, []string{"a/image.png"}},
+		{&Link{Target: "Readme"}, []string{"Other/Readme.md"}},
+		{&Link{Target: "Readme.md", Markdown: true}, []string{}},
+		{&Link{Target: "Missing"}, []string{}},
+		{&Link{Target: ""}, []string{"a/Index.md"}},
+	}
+
+	for _, testCase := range cases {
+		actual := []string{}
+		for _, fpath := range resolver.Resolve(source, testCase.link) {
+			actual = append(actual, filepath.ToSlash(vault.RelativePath(fpath)))
+		}
+
+		if len(actual) != len(testCase.expected) || (len(actual) > 0 && actual[0] != testCase.expected[0]) {
+			t.Errorf("Resolve(%q) = %v, expected %v", testCase.link.Target, actual, testCase.expected)
+		}
+	}
+
+	// bare names shared by several notes, from a note in neither folder
+	matches := resolver.Resolve(filepath.Join(vault.dpath, "Home.md"), &Link{Target: "Note"})
+	if len(matches) != 2 {
+		t.Errorf("expected an ambiguous link to match 2 notes, got %v", matches)
+	}
+}
+
+func TestParseLinks(t *testing.T) {
+	content := "---\nrelated: \"[[Frontmatter]]\"\n---\n" +
+		"See [[Note#Heading|alias]] and ![[image.png]] and [[Other#^block]].\n" +
+		"| [[Table\\|cell]] | `[[Code]]` |\n" +
+		"```\n[[Fenced]]\n```\n" +
+		"[md](Some%20Note.md#Part) [angle](<Spaced Note.md>) [web](https://example.com)\n"
+
+	expected := []Link{
+		{Raw: "[[Note#Heading|alias]]", Target: "Note", Heading: "Heading", Line: 4, Column: 4},
+		{Raw: "![[image.png]]", Target: "image.png", Embed: true, Line: 4, Column: 31},
+		{Raw: "[[Other#^block]]", Target: "Other", Block: "block", Line: 4, Column: 50},
+		{Raw: "[[Table\\|cell]]", Target: "Table", Line: 5, Column: 2},
+		{Raw: "[md](Some%20Note.md#Part)", Target: "Some Note.md", Heading: "Part", Markdown: true, Line: 9, Column: 0},
+		{Raw: "[angle](<Spaced Note.md>)", Target: "Spaced Note.md", Markdown: true, Line: 9, Column: 26},
+	}
+
+	links := ParseLinks([]byte(content))
+	if len(links) != len(expected) {
+		t.Fatalf("expected %d links, got %d", len(expected), len(links))
+	}
+
+	for idx, link := range links {
+		if *link != expected[idx] {
+			t.Errorf("link %d = %+v, expected %+v", idx, *link, expected[idx])
+		}
+	}
+}
+
+func TestParseAnchors(t *testing.T) {
+	headings, blocks := ParseAnchors([]byte("# Top Level\n## [[Linked]] Heading ##\n#tag\nA paragraph ^para-1\n```\n# not a heading\n```\n"))
+
+	for _, heading := range []string{"top level", "linked heading"} {
+		if !headings.Has(heading) {
+			t.Errorf("expected heading %q", heading)
+		}
+	}
+
+	if headings.Has("tag") || headings.Has("not a heading") {
+		t.Error("tags and fenced lines are not headings")
+	}
+
+	if !blocks.Has("para-1") {
+		t.Error("expected block para-1")
+	}
+}
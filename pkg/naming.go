@@ -0,0 +1,76 @@
+package opal
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+ * How many alternative suffixes to try before giving up on a filename
+ */
+const MaxNameAttempts = 100
+
+/*
+ * The filename prefix for records without a usable date. It is fixed, so
+ * such notes get the same name whenever they are first synced.
+ */
+const UnknownFileDate = "19700101"
+
+/*
+ * Format a date as a filename prefix. Dates that are missing or cannot be
+ * parsed fall back to UnknownFileDate.
+ */
+func FileDate(layout string, value string) string {
+	date, err := time.Parse(layout, value)
+	if err != nil {
+		return UnknownFileDate
+	}
+
+	return date.Format("20060102")
+}
+
+/*
+ * A four-digit suffix derived from a note's identity, so the same bookmark
+ * or star always produces the same filename. Later attempts step through
+ * neighbouring suffixes to resolve clashes.
+ */
+func StableSuffix(identity string, attempt int) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(identity))
+
+	return fmt.Sprintf("%04d", (int(hash.Sum32()%10000)+attempt)%10000)
+}
+
+/*
+ * Choose a path for a generated note in a vault folder. An existing file
+ * holding the same identity in its frontmatter is reused, and reported
+ * as existing; files belonging to other notes are never overwritten.
+ */
+func (vault *ObsidianVault) ChooseNotePath(folder string, date string, title string, key string, identity string) (string, bool, error) {
+	for attempt := 0; attempt < MaxNameAttempts; attempt++ {
+		fname := date + StableSuffix(identity, attempt) + " - " + title + ".md"
+		fpath := filepath.Join(vault.dpath, folder, fname)
+
+		content, err := vault.plan.ReadFile(fpath)
+		if errors.Is(err, os.ErrNotExist) {
+			return fpath, false, nil
+		}
+
+		if err != nil {
+			return "", false, err
+		}
+
+		block, _ := SplitFrontmatter(content)
+		fm, err := ParseFrontmatterDocument(block)
+
+		if err == nil && fm.GetString(key) == identity {
+			return fpath, true, nil
+		}
+	}
+
+	return "", false, errors.New("could not find a free filename for " + identity + " in " + folder)
+}
@@ -0,0 +1,33 @@
+package opal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFileDate(t *testing.T) {
+	cases := map[string]string{
+		"2022-02-07T21:51:23Z": "20220207",
+		"":                     UnknownFileDate,
+		"not a date":           UnknownFileDate,
+	}
+
+	for value, expected := range cases {
+		if actual := FileDate(time.RFC3339, value); actual != expected {
+			t.Errorf("FileDate(%q) = %s, expected %s", value, actual, expected)
+		}
+	}
+}
+
+func TestStarsWithoutDatesHaveStableNames(t *testing.T) {
+	repo := &StarredRepository{Name: "rgrannell1/opal"}
+
+	if date := repo.Date(); date != UnknownFileDate {
+		t.Errorf("expected undated stars to use %s, got %s", UnknownFileDate, date)
+	}
+
+	note := NewObsidianNote("/vault", "/vault/"+repo.Date()+StableSuffix(repo.Identity(), 0)+" - Rgrannell1 Opal.md")
+	if created, ok := note.Created(); ok {
+		t.Errorf("expected no creation date for an undated star, got %s", created)
+	}
+}
@@ -1,11 +1,14 @@
 package opal
 
 import (
-	"errors"
-	"io/ioutil"
+	"bytes"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 /*
@@ -19,10 +22,11 @@ type ObsidianNote struct {
 }
 
 /*
- * Construct an Obsidian note representation
+ * Construct an Obsidian note representation. Notes may be nested
+ * in subfolders of the vault directory.
  */
 func NewObsidianNote(dpath string, fpath string) *ObsidianNote {
-	baseName := strings.TrimPrefix(fpath, dpath+"/")
+	baseName := filepath.Base(fpath)
 	parts := strings.SplitN(baseName, " - ", 2)
 
 	if len(parts) != 2 {
@@ -45,79 +49,238 @@ func NewObsidianNote(dpath string, fpath string) *ObsidianNote {
 }
 
 /*
- * Merge updated frontmatter into existing frontmatter.
+ * The creation date encoded in the filename prefix, as an ISO date. Prefixes
+ * begin with a `20060102` date, optionally followed by further digits;
+ * UnknownFileDate is not a creation date.
+ */
+func (note *ObsidianNote) Created() (string, bool) {
+	prefix := strconv.Itoa(note.date)
+	if len(prefix) < 8 || strings.HasPrefix(prefix, UnknownFileDate) {
+		return "", false
+	}
+
+	created, err := time.Parse("20060102", prefix[0:8])
+	if err != nil {
+		return "", false
+	}
+
+	return created.Format("2006-01-02"), true
+}
+
+/*
+ * The frontmatter keys Opal computes for every note
  *
  */
-func (note *ObsidianNote) UpdateFrontmatter() {
+func (note *ObsidianNote) ComputedFrontmatter() *FrontmatterUpdate {
+	update := &FrontmatterUpdate{
+		Fields: []*FrontmatterField{
+			{Key: "title", Value: note.Title()},
+		},
+	}
 
+	if created, ok := note.Created(); ok {
+		update.Fields = append(update.Fields, &FrontmatterField{
+			Key:   "created",
+			Value: created,
+			Tag:   "!!timestamp",
+		})
+	}
+
+	return update
 }
 
 /*
- * Update a note's frontmatter with tags, title if the file
- * is unprocessed or the hash has changed since last processing
+ * A note whose frontmatter could not be parsed. Such notes are skipped
+ * rather than failing the run.
  */
-func (note *ObsidianNote) FixFrontmatter(conn *OpalDb) error {
-	changed, err := note.Changed(conn)
+type FrontmatterError struct {
+	Fpath string
+	Err   error
+}
+
+func (err *FrontmatterError) Error() string {
+	return "failed parsing frontmatter in " + err.Fpath + ": " + err.Err.Error()
+}
+
+func (err *FrontmatterError) Unwrap() error {
+	return err.Err
+}
 
+/*
+ * Merge updated frontmatter into existing frontmatter. Unknown keys, key order,
+ * comments and quoting style are preserved, and the body is left untouched.
+ */
+func (note *ObsidianNote) UpdateFrontmatter(update *FrontmatterUpdate, plan *Plan) error {
+	content, err := note.Read(plan)
 	if err != nil {
 		return err
 	}
 
-	if !changed {
+	merged, changed, err := MergeFrontmatter(content, update)
+	if err != nil {
+		return &FrontmatterError{Fpath: note.fpath, Err: err}
+	}
+
+	if len(changed) == 0 {
 		return nil
 	}
 
-	// ensure title is present
+	return plan.WriteFile(note.fpath, merged, "frontmatter updated: "+strings.Join(changed, ", "))
+}
 
-	return nil
+/*
+ * Update a note's frontmatter with tags, title. Callers select which notes
+ * need fixing; see ObsidianVault.ListModifiedMarkdown
+ */
+func (note *ObsidianNote) FixFrontmatter(conn *OpalDb, plan *Plan) error {
+	return note.UpdateFrontmatter(note.ComputedFrontmatter(), plan)
+}
+
+/*
+ * Read the note as it will be once the plan is applied, so each fix builds
+ * on the changes planned before it
+ */
+func (note *ObsidianNote) Read(plan *Plan) ([]byte, error) {
+	return plan.ReadFile(note.fpath)
+}
+
+/*
+ * Split note content into its YAML frontmatter block (including the `---`
+ * delimiters) and the remaining body. Notes without frontmatter have an empty
+ * frontmatter block.
+ */
+func SplitFrontmatter(content []byte) ([]byte, []byte) {
+	lines := bytes.SplitAfter(content, []byte("\n"))
+
+	if len(lines) == 0 || string(bytes.TrimRight(lines[0], "\r\n")) != "---" {
+		return []byte{}, content
+	}
+
+	offset := len(lines[0])
+	for _, line := range lines[1:] {
+		offset += len(line)
+
+		trimmed := string(bytes.TrimRight(line, "\r\n"))
+		if trimmed == "---" || trimmed == "..." {
+			return content[:offset], content[offset:]
+		}
+	}
+
+	// an unterminated frontmatter block is treated as body text
+	return []byte{}, content
 }
 
-func (note *ObsidianNote) Read() ([]byte, error) {
-	return ioutil.ReadFile(note.fpath)
+/*
+ * Does the markdown body contain a level-one heading? Headings inside fenced
+ * code-blocks are ignored, and `#tag` lines are not headings.
+ */
+func HasLevelOneHeading(body []byte) bool {
+	fence := ""
+	previous := ""
+
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimLeft(line, " ")
+
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[0:3]
+			continue
+		}
+
+		// headings may be indented by up to three spaces
+		if len(line)-len(trimmed) <= 3 {
+			if trimmed == "#" || strings.HasPrefix(trimmed, "# ") || strings.HasPrefix(trimmed, "#\t") {
+				return true
+			}
+
+			// setext headings underline a paragraph line with '='
+			if strings.TrimSpace(previous) != "" && len(strings.TrimSpace(trimmed)) > 0 && strings.Trim(strings.TrimSpace(trimmed), "=") == "" {
+				return true
+			}
+		}
+
+		previous = line
+	}
+
+	return false
 }
 
-func (note *ObsidianNote) HasTitle() (bool, error) {
-	content, err := note.Read()
+/*
+ * Does the note have a level-one heading after any frontmatter?
+ *
+ */
+func (note *ObsidianNote) HasTitle(plan *Plan) (bool, error) {
+	content, err := note.Read(plan)
 
 	if err != nil {
 		return false, err
 	}
 
-	lines := string(content)
-	panic(lines)
+	_, body := SplitFrontmatter(content)
+
+	return HasLevelOneHeading(body), nil
 }
 
 /*
- * Write document title to a markdown file, if the file is unprocessed or
- * the hash has changed since last processing
+ * The note title, derived from the `<date> - <name>.md` filename
+ *
  */
-func (note *ObsidianNote) WriteTitle(conn *OpalDb) error {
-	return nil
+func (note *ObsidianNote) Title() string {
+	return strings.TrimSuffix(note.name, filepath.Ext(note.name))
 }
 
 /*
- * Write document title to a markdown file, if the file is unprocessed or
- * the hash has changed since last processing
+ * Insert a `# <name>` heading directly after the note's frontmatter. The
+ * frontmatter and body are otherwise left unchanged.
  */
-func (note *ObsidianNote) FixTitle(conn *OpalDb) error {
-	changed, err := note.Changed(conn)
+func (note *ObsidianNote) WriteTitle(conn *OpalDb, plan *Plan) error {
+	content, err := note.Read(plan)
 	if err != nil {
 		return err
 	}
 
-	if !changed {
-		return nil
+	frontmatter, body := SplitFrontmatter(content)
+
+	buf := new(bytes.Buffer)
+	buf.Write(frontmatter)
+
+	// the closing `---` may be the last line, without a newline
+	if len(frontmatter) > 0 && frontmatter[len(frontmatter)-1] != '\n' {
+		buf.WriteString("\n")
 	}
 
+	buf.WriteString("# " + note.Title() + "\n")
+
+	if len(body) > 0 && body[0] != '\n' && body[0] != '\r' {
+		buf.WriteString("\n")
+	}
+
+	buf.Write(body)
+
+	return plan.WriteFile(note.fpath, buf.Bytes(), "missing title")
+}
+
+/*
+ * Write document title to a markdown file, if missing. Callers select which
+ * notes need fixing; see ObsidianVault.ListModifiedMarkdown
+ */
+func (note *ObsidianNote) FixTitle(conn *OpalDb, plan *Plan) error {
 	// determine if a heading is present in the document; if not, insert the value
 	// in diatom (that was pulled from the file title)
-	present, err := note.HasTitle()
+	present, err := note.HasTitle(plan)
 	if err != nil {
 		return err
 	}
 
 	if !present {
-		err := note.WriteTitle(conn)
+		err := note.WriteTitle(conn, plan)
 		if err != nil {
 			return err
 		}
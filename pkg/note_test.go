@@ -0,0 +1,95 @@
+package opal
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFixFrontmatterSkipsMalformedNotes(t *testing.T) {
+	vault := newTestVault(t, map[string]string{
+		"20220101 - Broken.md": "---\ntitle: [unclosed\n---\n# Broken\n",
+		"20220102 - Fine.md":   "# Fine\n",
+	})
+
+	notes := []*ObsidianNote{
+		NewObsidianNote(vault.dpath, filepath.Join(vault.dpath, "20220101 - Broken.md")),
+		NewObsidianNote(vault.dpath, filepath.Join(vault.dpath, "20220102 - Fine.md")),
+	}
+
+	out := new(bytes.Buffer)
+	fixed, err := vault.FixFrontmatter(out, notes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fixed) != 1 || fixed[0] != notes[1] {
+		t.Fatalf("expected only the valid note to be fixed, got %d note(s)", len(fixed))
+	}
+
+	if !strings.Contains(out.String(), "skipping 20220101 - Broken.md") {
+		t.Errorf("expected the malformed note to be reported, got %q", out.String())
+	}
+
+	if actual := readTestFile(t, vault, "20220101 - Broken.md"); actual != "---\ntitle: [unclosed\n---\n# Broken\n" {
+		t.Errorf("malformed note was modified:\n%s", actual)
+	}
+
+	if actual := readTestFile(t, vault, "20220102 - Fine.md"); !strings.Contains(actual, "title: Fine") {
+		t.Errorf("valid note was not fixed:\n%s", actual)
+	}
+}
+
+func TestWriteTitle(t *testing.T) {
+	cases := map[string]string{
+		"---\ntitle: Hello\n---":         "---\ntitle: Hello\n---\n# Hello\n",
+		"---\ntitle: Hello\n---\nbody\n": "---\ntitle: Hello\n---\n# Hello\n\nbody\n",
+		"body\n":                         "# Hello\n\nbody\n",
+	}
+
+	for content, expected := range cases {
+		vault := newTestVault(t, map[string]string{"20220101 - Hello.md": content})
+		note := NewObsidianNote(vault.dpath, filepath.Join(vault.dpath, "20220101 - Hello.md"))
+
+		if err := note.WriteTitle(nil, vault.plan); err != nil {
+			t.Fatal(err)
+		}
+
+		if actual := readTestFile(t, vault, "20220101 - Hello.md"); actual != expected {
+			t.Errorf("WriteTitle(%q) wrote %q, expected %q", content, actual, expected)
+		}
+	}
+}
+
+func TestAuditPlansBuildOnEachOther(t *testing.T) {
+	content := "Some prose\n"
+	vault := newTestVault(t, map[string]string{"20220101 - Hello.md": content})
+	vault.plan.DryRun = true
+
+	fpath := filepath.Join(vault.dpath, "20220101 - Hello.md")
+	notes := []*ObsidianNote{NewObsidianNote(vault.dpath, fpath)}
+
+	notes, err := vault.FixFrontmatter(new(bytes.Buffer), notes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := vault.FixTitle(notes, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the title fix keeps the frontmatter fix planned before it
+	planned, err := vault.plan.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(planned), "title: Hello") || !strings.Contains(string(planned), "# Hello") {
+		t.Errorf("expected both fixes in the planned content, got:\n%s", planned)
+	}
+
+	if actual := readTestFile(t, vault, "20220101 - Hello.md"); actual != content {
+		t.Errorf("audit mode modified the note:\n%s", actual)
+	}
+}
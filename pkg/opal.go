@@ -3,98 +3,290 @@ package opal
 import (
 	"os"
 	"path/filepath"
-
-	copper "github.com/rgrannell1/coppermind/pkg"
-	diatom "github.com/rgrannell1/diatom/pkg"
 )
 
 type OpalArgs struct {
-	Fpath string
-	Audit bool
-	Fix   bool
+	Fpath      string
+	Audit      bool
+	Fix        bool
+	Force      bool
+	Reconcile  bool
+	Orphans    string
+	Prefixes   *TagPrefixes
+	Templates  string
+	Rules      string
+	Merge      bool
+	Offline    bool
+	Fetcher    Fetcher
+	Db         string
+	Index      string
+	Indexer    Indexer
+	Folders    FolderConfig
+	Validation *ValidationConfig
+	Format     string
 }
 
 /*
- * Main application; audit or fix Obsidian notes
+ * The diatom database coppermind fetches bookmarks and stars into
+ *
  */
-func Opal(args *OpalArgs) error {
-	vault := ObsidianVault{args.Fpath}
+func CoppermindDatabasePath() (string, error) {
 	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".diatom.sqlite"), nil
+}
+
+/*
+ * The database for a vault: the configured path (from --db, OPAL_DB or
+ * opal.yaml), otherwise `.opal/opal.sqlite` in the vault, so vaults can run
+ * side by side. Without a vault, coppermind's database is used.
+ */
+func DatabasePath(dbpath string, vaultDir string) (string, error) {
+	if dbpath != "" {
+		return dbpath, nil
+	}
+
+	if vaultDir != "" {
+		return filepath.Join(vaultDir, ".opal", "opal.sqlite"), nil
+	}
+
+	return CoppermindDatabasePath()
+}
+
+/*
+ * Check each template renders valid notes, using a few rows from the
+ * database when it exists
+ */
+func OpalCheckTemplates(args *OpalArgs) error {
+	dirs := TemplateSearchPath(args.Templates, args.Fpath)
+
+	dbpath, err := DatabasePath(args.Db, args.Fpath)
 	if err != nil {
 		return err
 	}
 
-	dbpath := filepath.Join(home, ".diatom.sqlite")
-	err = diatom.Diatom(&diatom.DiatomArgs{
-		Dir:    args.Fpath,
-		DBPath: dbpath,
-	})
+	var conn *OpalDb
+	if _, err := os.Stat(dbpath); err == nil {
+		conn, err = NewOpalDb(dbpath)
+		if err != nil {
+			return err
+		}
+	}
 
+	rules, err := LoadRules(RulesSearchPath(args.Rules, args.Fpath))
 	if err != nil {
 		return err
 	}
 
-	err = copper.Coppermind()
+	sources := DefaultSources(&SourceOptions{Rules: rules, Folders: args.Folders})
+
+	return CheckTemplates(os.Stdout, sources, dirs, conn, args.Prefixes, 3)
+}
+
+/*
+ * Main application; audit or fix Obsidian notes. In audit mode the full
+ * pipeline runs, but no vault files are written and the planned changes
+ * are printed instead.
+ */
+func Opal(args *OpalArgs) error {
+	session, err := NewSession(args)
 	if err != nil {
 		return err
 	}
 
-	conn, err := NewOpalDb(dbpath)
+	if err := session.Index(); err != nil {
+		return err
+	}
+
+	if err := session.Fetch(); err != nil {
+		return err
+	}
+
+	if err := session.FixNotes(); err != nil {
+		return err
+	}
+
+	// generate bookmark and star files using coppermind and diatom data
+	sources, err := session.Sources()
 	if err != nil {
 		return err
 	}
 
-	err = conn.CreateTables()
+	if err := session.Sync(sources); err != nil {
+		return err
+	}
+
+	if err := session.Finish(); err != nil {
+		return err
+	}
+
+	return session.Validate()
+}
+
+/*
+ * Fix note frontmatter and titles, without syncing sources
+ *
+ */
+func OpalFix(args *OpalArgs) error {
+	session, err := NewSession(args)
 	if err != nil {
 		return err
 	}
 
-	// list modified files and modify them
-	notes, err := vault.ListModifiedMarkdown(conn)
-	if err := vault.FixFrontmatter(notes, conn); err != nil {
+	if err := session.Index(); err != nil {
 		return err
 	}
 
-	if err := vault.FixTitle(notes, conn); err != nil {
+	if err := session.FixNotes(); err != nil {
 		return err
 	}
 
-	err = conn.MarkComplete(notes)
+	return session.Finish()
+}
+
+/*
+ * Sync a single kind of source, such as bookmarks or stars, without
+ * fixing notes. Coppermind fetches every source, unless offline.
+ */
+func OpalSync(args *OpalArgs, kind string) error {
+	session, err := NewSession(args)
+	if err != nil {
+		return err
+	}
+
+	sources, err := session.Sources()
 	if err != nil {
 		return err
 	}
 
-	ex, err := os.Executable()
+	sources, err = SelectSources(sources, kind)
 	if err != nil {
 		return err
 	}
 
-	root := filepath.Dir(ex)
+	if err := session.Index(); err != nil {
+		return err
+	}
 
-	// generate bookmark files using coppermind and diatom data
-	err = SyncBookmarks(filepath.Join(root, "./pinboard-template.txt"), &vault, conn)
+	if err := session.Fetch(); err != nil {
+		return err
+	}
+
+	if err := session.Sync(sources); err != nil {
+		return err
+	}
+
+	return session.Finish()
+}
+
+/*
+ * Re-index the vault and check it for problems, without changing notes
+ *
+ */
+func OpalValidate(args *OpalArgs) error {
+	session, err := NewSession(args)
+	if err != nil {
+		return err
+	}
+
+	if err := session.Index(); err != nil {
+		return err
+	}
+
+	return session.Validate()
+}
+
+/*
+ * List notes breaking the filename convention, or rename them and
+ * rewrite the links pointing at them
+ */
+func OpalNames(args *OpalArgs, rename bool) error {
+	session, err := NewSession(args)
+	if err != nil {
+		return err
+	}
+
+	if !rename {
+		return session.ListFilenames()
+	}
+
+	if err := session.FixFilenames(); err != nil {
+		return err
+	}
+
+	return session.Finish()
+}
+
+/*
+ * Move a note or attachment, rewriting the links to it, then re-index so
+ * the database knows the new path
+ */
+func OpalMove(args *OpalArgs, from string, to string) error {
+	session, err := NewSession(args)
 	if err != nil {
 		return err
 	}
 
-	err = SyncGithubStars(filepath.Join(root, "./github-template.txt"), &vault, conn)
+	if err := session.Move(from, to); err != nil {
+		return err
+	}
+
+	return session.Finish()
+}
+
+/*
+ * Index the vault into the database
+ *
+ */
+func OpalIndex(args *OpalArgs) error {
+	session, err := NewSession(args)
 	if err != nil {
 		return err
 	}
 
-	// update database information after opal modifications with a second call to diatom
-	err = diatom.Diatom(&diatom.DiatomArgs{
-		Dir:    args.Fpath,
-		DBPath: dbpath,
-	})
+	return session.Index()
+}
+
+/*
+ * Summarise the vault and sources, as of the last index
+ *
+ */
+func OpalStatus(args *OpalArgs) error {
+	session, err := NewSession(args)
+	if err != nil {
+		return err
+	}
 
+	sources, err := session.Sources()
 	if err != nil {
 		return err
 	}
 
-	if err := vault.Validate(conn); err != nil {
+	return session.Status(os.Stdout, sources)
+}
+
+/*
+ * Report bookmarks that share a canonical URL
+ *
+ */
+func OpalDuplicates(args *OpalArgs) error {
+	dbpath, err := DatabasePath(args.Db, args.Fpath)
+	if err != nil {
+		return err
+	}
+
+	conn, err := NewOpalDb(dbpath)
+	if err != nil {
+		return err
+	}
+
+	bookmarks, err := conn.ListBookmarks()
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return ReportDuplicateBookmarks(os.Stdout, DuplicateBookmarks(bookmarks))
 }
@@ -0,0 +1,174 @@
+package opal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	OrphanReport  = "report"
+	OrphanTag     = "tag"
+	OrphanArchive = "archive"
+)
+
+/*
+ * Orphaned notes are moved beneath this vault folder in archive mode
+ */
+const ArchiveFolder = "opal-archive"
+
+/*
+ * A generated note whose upstream bookmark or star no longer exists
+ */
+type OrphanedNote struct {
+	Fpath    string
+	Key      string
+	Identity string
+	Tag      string
+}
+
+/*
+ * List notes whose identities (for example `bookmark_hash` or `github_repo`)
+ * are all absent from their source's table. A source with an empty table is
+ * skipped, so a failed fetch doesn't orphan every note.
+ */
+func ListOrphanedNotes(conn *OpalDb, dir string, sources []Source) ([]*OrphanedNote, error) {
+	orphans := []*OrphanedNote{}
+
+	frontmatter, err := GetFrontmatter(conn, dir)
+	if err != nil {
+		return orphans, err
+	}
+
+	for _, source := range sources {
+		records, err := source.List(conn)
+		if err != nil {
+			return orphans, err
+		}
+
+		if len(records) == 0 {
+			continue
+		}
+
+		identities := NewSet([]string{})
+		for _, record := range records {
+			for _, identity := range RecordIdentities(record) {
+				identities.Add(identity)
+			}
+		}
+
+		for _, fm := range frontmatter {
+			values := fm.Values(source.Key())
+			if len(values) == 0 {
+				continue
+			}
+
+			// a merged note survives while any of its records do
+			present := false
+			for _, identity := range values {
+				present = present || identities.Has(identity)
+			}
+
+			if present {
+				continue
+			}
+
+			orphans = append(orphans, &OrphanedNote{
+				Fpath:    fm.FileId,
+				Key:      source.Key(),
+				Identity: strings.Join(values, ", "),
+				Tag:      source.DeletedTag(),
+			})
+		}
+	}
+
+	return orphans, nil
+}
+
+/*
+ * Check an orphan mode is report, tag or archive; empty means report
+ *
+ */
+func ValidateOrphanMode(mode string) error {
+	switch mode {
+	case "", OrphanReport, OrphanTag, OrphanArchive:
+		return nil
+	default:
+		return errors.New("unknown orphan mode '" + mode + "'; expected report, tag or archive")
+	}
+}
+
+/*
+ * Report, tag or archive notes for bookmarks and stars deleted upstream
+ *
+ */
+func HandleOrphans(out io.Writer, vault *ObsidianVault, conn *OpalDb, sources []Source, mode string) error {
+	if err := ValidateOrphanMode(mode); err != nil {
+		return err
+	}
+
+	orphans, err := ListOrphanedNotes(conn, vault.dpath, sources)
+	if err != nil {
+		return err
+	}
+
+	for _, orphan := range orphans {
+		// only notes inside the vault are archived within it
+		if !vault.Contains(orphan.Fpath) {
+			continue
+		}
+
+		// the index may list notes that have since been removed
+		if _, err := os.Stat(orphan.Fpath); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		// archived notes have already been handled
+		if strings.HasPrefix(filepath.ToSlash(vault.RelativePath(orphan.Fpath)), ArchiveFolder+"/") {
+			continue
+		}
+
+		reason := orphan.Key + " " + orphan.Identity + " was deleted upstream"
+
+		switch mode {
+		case "", OrphanReport:
+			_, err = fmt.Fprintf(out, "orphaned %s\n         reason: %s\n", vault.RelativePath(orphan.Fpath), reason)
+		case OrphanTag:
+			err = vault.TagOrphan(orphan, reason)
+		case OrphanArchive:
+			target := filepath.Join(vault.dpath, ArchiveFolder, vault.RelativePath(orphan.Fpath))
+			err = vault.plan.Rename(orphan.Fpath, target, reason)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Add a deletion tag to an orphaned note's frontmatter
+ *
+ */
+func (vault *ObsidianVault) TagOrphan(orphan *OrphanedNote, reason string) error {
+	content, err := vault.plan.ReadFile(orphan.Fpath)
+	if err != nil {
+		return err
+	}
+
+	updated, changed, err := MergeFrontmatter(content, &FrontmatterUpdate{Tags: []string{orphan.Tag}})
+	if err != nil {
+		return err
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	return vault.plan.WriteFile(orphan.Fpath, updated, reason)
+}
@@ -0,0 +1,183 @@
+package opal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ACTION_CREATE = "create"
+	ACTION_MODIFY = "modify"
+	ACTION_MOVE   = "move"
+)
+
+/*
+ * A change Opal made, or would make, to the vault
+ */
+type PlannedChange struct {
+	Action  string
+	Fpath   string
+	Target  string
+	Reason  string
+	content []byte
+}
+
+/*
+ * Records every change Opal makes to the vault. In dry-run mode changes
+ * are only recorded, and nothing is written to disk.
+ */
+type Plan struct {
+	DryRun bool
+	// the vault directory; files are never moved out of it
+	Root    string
+	Changes []*PlannedChange
+}
+
+/*
+ * Construct a plan for changes to the vault at root
+ */
+func NewPlan(dryRun bool, root string) *Plan {
+	return &Plan{
+		DryRun:  dryRun,
+		Root:    root,
+		Changes: []*PlannedChange{},
+	}
+}
+
+/*
+ * Write content to a file, creating parent directories as required. In
+ * dry-run mode the change is recorded but the file is not written.
+ *
+ */
+func (plan *Plan) WriteFile(fpath string, content []byte, reason string) error {
+	action := ACTION_MODIFY
+
+	if previous := plan.planned(fpath); previous != nil {
+		action = previous.Action
+	} else if _, err := os.Stat(fpath); errors.Is(err, os.ErrNotExist) {
+		action = ACTION_CREATE
+	} else if err != nil {
+		return err
+	}
+
+	plan.Changes = append(plan.Changes, &PlannedChange{
+		Action:  action,
+		Fpath:   fpath,
+		Reason:  reason,
+		content: content,
+	})
+
+	if plan.DryRun {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(fpath), 0700)
+	if err != nil {
+		return errors.Wrap(err, "failed creating "+filepath.Dir(fpath))
+	}
+
+	err = os.WriteFile(fpath, content, 0700)
+	if err != nil {
+		return errors.Wrap(err, "failed writing "+fpath)
+	}
+
+	return nil
+}
+
+/*
+ * Move a file, creating parent directories as required. Existing files are
+ * never overwritten. In dry-run mode the move is recorded but not performed.
+ */
+func (plan *Plan) Rename(fpath string, target string, reason string) error {
+	if !withinDir(plan.Root, target) {
+		return errors.New("cannot move " + fpath + " to " + target + ", which is outside the vault")
+	}
+
+	if _, err := os.Stat(target); err == nil || plan.planned(target) != nil {
+		return errors.New("cannot move " + fpath + ", " + target + " already exists")
+	}
+
+	plan.Changes = append(plan.Changes, &PlannedChange{
+		Action: ACTION_MOVE,
+		Fpath:  fpath,
+		Target: target,
+		Reason: reason,
+	})
+
+	if plan.DryRun {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(target), 0700)
+	if err != nil {
+		return errors.Wrap(err, "failed creating "+filepath.Dir(target))
+	}
+
+	err = os.Rename(fpath, target)
+	if err != nil {
+		return errors.Wrap(err, "failed moving "+fpath)
+	}
+
+	return nil
+}
+
+/*
+ * Find the most recent planned change to a file, or nil
+ *
+ */
+func (plan *Plan) planned(fpath string) *PlannedChange {
+	for idx := len(plan.Changes) - 1; idx >= 0; idx-- {
+		if plan.Changes[idx].Fpath == fpath {
+			return plan.Changes[idx]
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Read a file as it will be once the plan is applied; in dry-run mode this
+ * includes files that were planned but never written.
+ */
+func (plan *Plan) ReadFile(fpath string) ([]byte, error) {
+	if change := plan.planned(fpath); change != nil && plan.DryRun {
+		return change.content, nil
+	}
+
+	return os.ReadFile(fpath)
+}
+
+/*
+ * Print each planned change, with file-paths relative to the vault
+ *
+ */
+func (plan *Plan) Report(out io.Writer, vault *ObsidianVault) error {
+	verb := "made"
+	if plan.DryRun {
+		verb = "would make"
+	}
+
+	_, err := fmt.Fprintf(out, "opal %s %d change(s)\n", verb, len(plan.Changes))
+	if err != nil {
+		return err
+	}
+
+	for _, change := range plan.Changes {
+		fpath := vault.RelativePath(change.Fpath)
+
+		if change.Action == ACTION_MOVE {
+			fpath += " -> " + vault.RelativePath(change.Target)
+		}
+
+		_, err := fmt.Fprintf(out, "%-8s %s\n         reason: %s\n", change.Action, fpath, change.Reason)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
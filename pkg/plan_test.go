@@ -0,0 +1,49 @@
+package opal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlanRenameStaysInVault(t *testing.T) {
+	vault := newTestVault(t, map[string]string{"20220101 - Note.md": "# Note\n"})
+	source := filepath.Join(vault.dpath, "20220101 - Note.md")
+
+	for _, target := range []string{
+		filepath.Join(vault.dpath, "..", "20220101 - Note.md"),
+		filepath.Join(filepath.Dir(vault.dpath), "elsewhere", "20220101 - Note.md"),
+		vault.dpath,
+	} {
+		if err := vault.plan.Rename(source, target, "test"); err == nil {
+			t.Errorf("expected moving to %s to be rejected", target)
+		}
+	}
+
+	target := filepath.Join(vault.dpath, ArchiveFolder, "20220101 - Note.md")
+	if err := vault.plan.Rename(source, target, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("expected the note to move within the vault: %v", err)
+	}
+}
+
+func TestVaultContains(t *testing.T) {
+	vault := newTestVault(t, map[string]string{})
+
+	cases := map[string]bool{
+		filepath.Join(vault.dpath, "note.md"):           true,
+		filepath.Join(vault.dpath, "folder", "note.md"): true,
+		filepath.Join(vault.dpath, "..", "note.md"):     false,
+		filepath.Join(vault.dpath+"-other", "note.md"):  false,
+		vault.dpath: false,
+	}
+
+	for fpath, expected := range cases {
+		if actual := vault.Contains(fpath); actual != expected {
+			t.Errorf("Contains(%s): expected %v, got %v", fpath, expected, actual)
+		}
+	}
+}
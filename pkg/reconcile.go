@@ -0,0 +1,216 @@
+package opal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * Find a fenced code-block with a particular info-string, such as `!pinboard`.
+ * Returns the byte offsets of the block, including its fence lines.
+ */
+func FindFencedBlock(content []byte, info string) (int, int, bool) {
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	offset := 0
+	start := -1
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(string(line))
+
+		if start < 0 && trimmed == "```"+info {
+			start = offset
+		} else if start >= 0 && trimmed == "```" {
+			return start, offset + len(line), true
+		}
+
+		offset += len(line)
+	}
+
+	return 0, 0, false
+}
+
+/*
+ * Parse the YAML-like body of a fenced block, for comparison
+ *
+ */
+func parseFencedBlock(block []byte) (map[string]interface{}, error) {
+	lines := bytes.SplitAfter(bytes.TrimRight(block, "\r\n"), []byte("\n"))
+	data := map[string]interface{}{}
+
+	if len(lines) < 2 {
+		return data, errors.New("fenced block is empty")
+	}
+
+	err := yaml.Unmarshal(bytes.Join(lines[1:len(lines)-1], []byte{}), &data)
+	return data, err
+}
+
+/*
+ * Do two fenced blocks hold the same data? Blocks are compared as YAML
+ * where possible, so formatting differences are ignored.
+ */
+func SameFencedBlock(first []byte, second []byte) bool {
+	firstData, firstErr := parseFencedBlock(first)
+	secondData, secondErr := parseFencedBlock(second)
+
+	if firstErr != nil || secondErr != nil {
+		return bytes.Equal(bytes.TrimSpace(first), bytes.TrimSpace(second))
+	}
+
+	return reflect.DeepEqual(firstData, secondData)
+}
+
+/*
+ * The frontmatter keys a template writes are owned by Opal, and are
+ * overwritten on reconciliation. Tags are merged rather than replaced:
+ * tags under the source's prefixes that are no longer rendered are removed,
+ * and user-added tags survive.
+ */
+func OwnedFrontmatter(rendered []byte, tagPrefixes []string) (*FrontmatterUpdate, error) {
+	block, _ := SplitFrontmatter(rendered)
+	update := &FrontmatterUpdate{Fields: []*FrontmatterField{}, OwnedPrefix: tagPrefixes}
+
+	fm, err := ParseFrontmatterDocument(block)
+	if err != nil {
+		return update, err
+	}
+
+	mapping := fm.mapping()
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		key := mapping.Content[idx].Value
+		value := mapping.Content[idx+1]
+
+		if key == "tags" {
+			update.Tags = fm.GetList("tags")
+			continue
+		}
+
+		if value.Kind != yaml.ScalarNode {
+			continue
+		}
+
+		update.Fields = append(update.Fields, &FrontmatterField{
+			Key:      key,
+			Value:    value.Value,
+			Tag:      value.Tag,
+			Override: true,
+		})
+	}
+
+	return update, nil
+}
+
+/*
+ * Bring a generated note in line with freshly rendered content. If the note's
+ * fenced block differs from the rendered one, the block and Opal-owned
+ * frontmatter keys are rewritten; the user's own prose is left alone.
+ */
+func (vault *ObsidianVault) ReconcileNote(fpath string, rendered []byte, info string, tagPrefixes []string, reason string) error {
+	content, err := vault.plan.ReadFile(fpath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	renderedStart, renderedEnd, ok := FindFencedBlock(rendered, info)
+	if !ok {
+		return errors.New("template has no ```" + info + " block")
+	}
+
+	start, end, ok := FindFencedBlock(content, info)
+	if !ok {
+		// the user removed the block; don't reinstate it
+		return nil
+	}
+
+	block := rendered[renderedStart:renderedEnd]
+	if SameFencedBlock(content[start:end], block) {
+		return nil
+	}
+
+	updated := append([]byte{}, content[:start]...)
+	updated = append(updated, block...)
+	updated = append(updated, content[end:]...)
+
+	owned, err := OwnedFrontmatter(rendered, tagPrefixes)
+	if err != nil {
+		return errors.Wrap(err, "failed parsing rendered frontmatter")
+	}
+
+	// keys holding lists, like the hashes of merged bookmarks, are not collapsed to a scalar
+	header, _ := SplitFrontmatter(content)
+	if existing, err := ParseFrontmatterDocument(header); err == nil {
+		fields := []*FrontmatterField{}
+
+		for _, field := range owned.Fields {
+			if node := existing.Get(field.Key); node == nil || node.Kind != yaml.SequenceNode {
+				fields = append(fields, field)
+			}
+		}
+
+		owned.Fields = fields
+	}
+
+	updated, _, err = MergeFrontmatter(updated, owned)
+	if err != nil {
+		return errors.Wrap(err, "failed parsing frontmatter in "+fpath)
+	}
+
+	return vault.plan.WriteFile(fpath, updated, reason)
+}
+
+/*
+ * Update existing notes whose source data has changed
+ *
+ */
+func ReconcileSource(source Source, tmpl *template.Template, vault *ObsidianVault, conn *OpalDb, prefixes *TagPrefixes) error {
+	notes, err := GetIdentityNotes(conn, vault.dpath, source.Key())
+	if err != nil {
+		return err
+	}
+
+	records, err := source.List(conn)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		fpath := ""
+		for _, identity := range RecordIdentities(record) {
+			if note, ok := notes[identity]; ok {
+				fpath = note
+				break
+			}
+		}
+
+		if fpath == "" {
+			continue
+		}
+
+		date := strings.SplitN(filepath.Base(fpath), " - ", 2)[0]
+
+		rendered, err := record.Render(tmpl, date, prefixes)
+		if err != nil {
+			return err
+		}
+
+		reason := source.Name() + " " + record.Identity() + " changed"
+
+		err = vault.ReconcileNote(fpath, rendered, source.Block(), source.TagPrefixes(prefixes), reason)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
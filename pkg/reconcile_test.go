@@ -0,0 +1,94 @@
+package opal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReconcileRewritesChangedBookmarks(t *testing.T) {
+	bookmark := []interface{}{"Go - Wikipedia", "An old summary", "abc123", "https://en.wikipedia.org/wiki/Go", "",
+		"yes", "golang reading", "2022-02-07T21:51:23Z", "no"}
+	fetcher := &fakeFetcher{bookmarks: [][]interface{}{bookmark}}
+
+	args := &OpalArgs{Fix: true, Fetcher: fetcher, Reconcile: true}
+	session := newTestSession(t, map[string]string{}, args)
+	vault := session.vault
+
+	if err := OpalSync(args, "bookmarks"); err != nil {
+		t.Fatal(err)
+	}
+
+	names := listTestFolder(t, vault, "pinboard-bookmarks")
+	if len(names) != 1 {
+		t.Fatalf("expected one bookmark note, got %v", names)
+	}
+
+	// the user adds their own tag and prose to the generated note
+	relpath := filepath.Join("pinboard-bookmarks", names[0])
+	content := readTestFile(t, vault, relpath)
+	content = strings.Replace(content, "tags: [", "tags: [mine, ", 1) + "\nMy own notes on Go.\n"
+	writeTestFile(t, filepath.Join(vault.dpath, relpath), content)
+
+	// then renames a tag and edits the summary in Pinboard
+	changed := append([]interface{}{}, bookmark...)
+	changed[1] = "A new summary"
+	changed[6] = "go reading"
+	fetcher.bookmarks = [][]interface{}{changed}
+
+	if err := OpalSync(args, "bookmarks"); err != nil {
+		t.Fatal(err)
+	}
+
+	updated := readTestFile(t, vault, relpath)
+
+	for _, expected := range []string{"A new summary", "pinboard/go", "pinboard/reading", "mine", "My own notes on Go."} {
+		if !strings.Contains(updated, expected) {
+			t.Errorf("expected %q in the reconciled note:\n%s", expected, updated)
+		}
+	}
+
+	for _, unexpected := range []string{"An old summary", "pinboard/golang"} {
+		if strings.Contains(updated, unexpected) {
+			t.Errorf("expected %q to be removed from the reconciled note:\n%s", unexpected, updated)
+		}
+	}
+}
+
+func TestReconcileLeavesUnchangedNotes(t *testing.T) {
+	content := "---\ntags: [pinboard/go, mine]\n---\n# Go\n\n```!pinboard\nhash: abc123\n```\n\nProse\n"
+	rendered := []byte("---\ntags: [pinboard/go]\n---\n# Go\n\n```!pinboard\nhash:   abc123\n```\n")
+
+	vault := newTestVault(t, map[string]string{"note.md": content})
+	fpath := filepath.Join(vault.dpath, "note.md")
+
+	if err := vault.ReconcileNote(fpath, rendered, "!pinboard", []string{"pinboard/"}, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vault.plan.Changes) != 0 {
+		t.Errorf("expected a note with the same block data to be left alone, got %d change(s)", len(vault.plan.Changes))
+	}
+
+	if actual, _ := os.ReadFile(fpath); string(actual) != content {
+		t.Errorf("note was modified:\n%s", actual)
+	}
+}
+
+func TestFrontmatterRemovesStaleOwnedTags(t *testing.T) {
+	content := []byte("---\ntags: [pinboard/old, Pinboard/Kept, mine, github/topic/go]\n---\nBody\n")
+
+	updated, changed, err := MergeFrontmatter(content, &FrontmatterUpdate{
+		Tags:        []string{"pinboard/kept", "pinboard/new"},
+		OwnedPrefix: []string{"pinboard/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "---\ntags: [Pinboard/Kept, mine, github/topic/go, pinboard/new]\n---\nBody\n"
+	if string(updated) != expected || len(changed) != 1 {
+		t.Errorf("expected\n%s\ngot\n%s (changed %v)", expected, updated, changed)
+	}
+}
@@ -0,0 +1,284 @@
+package opal
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * A planned move of a vault file
+ */
+type Rename struct {
+	From string
+	To   string
+}
+
+/*
+ * Replace the target of a wikilink, keeping any heading, block and alias
+ *
+ */
+func replaceWikilinkTarget(raw string, target string) string {
+	start := strings.Index(raw, "[[") + 2
+	end := len(raw) - 2
+	inner := raw[start:end]
+
+	cut := strings.IndexAny(inner, "#|")
+	if cut < 0 {
+		cut = len(inner)
+	}
+
+	// pipes are escaped inside tables
+	if cut > 0 && cut < len(inner) && inner[cut] == '|' && inner[cut-1] == '\\' {
+		cut--
+	}
+
+	return raw[:start] + target + inner[cut:] + raw[end:]
+}
+
+/*
+ * Replace the target of a markdown link, keeping any heading and title
+ *
+ */
+func replaceMarkdownTarget(raw string, target string) string {
+	start := strings.Index(raw, "](") + 2
+
+	if strings.HasPrefix(raw[start:], "<") {
+		start++
+		end := start + strings.IndexAny(raw[start:], "#>")
+		return raw[:start] + target + raw[end:]
+	}
+
+	end := start + strings.IndexAny(raw[start:], "# )")
+	return raw[:start] + strings.ReplaceAll(target, " ", "%20") + raw[end:]
+}
+
+/*
+ * A replacement for a parsed link
+ */
+type linkEdit struct {
+	link     *Link
+	replaced string
+}
+
+/*
+ * Splice replacement links into a note at the position each was parsed
+ * from. Edits apply right to left, so earlier offsets stay valid; links
+ * whose text no longer matches are left alone.
+ */
+func applyLinkEdits(content []byte, edits []*linkEdit) []byte {
+	sort.Slice(edits, func(idx, jdx int) bool {
+		if edits[idx].link.Line != edits[jdx].link.Line {
+			return edits[idx].link.Line > edits[jdx].link.Line
+		}
+
+		return edits[idx].link.Column > edits[jdx].link.Column
+	})
+
+	lines := strings.Split(string(content), "\n")
+	for _, edit := range edits {
+		link := edit.link
+		if link.Line < 1 || link.Line > len(lines) {
+			continue
+		}
+
+		line := lines[link.Line-1]
+		end := link.Column + len(link.Raw)
+
+		if end > len(line) || line[link.Column:end] != link.Raw {
+			continue
+		}
+
+		lines[link.Line-1] = line[:link.Column] + edit.replaced + line[end:]
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+/*
+ * The link text pointing at a moved file from a (possibly moved) note, in
+ * the style of the original link: bare names stay bare unless the new name
+ * is ambiguous, paths stay paths, and the `.md` extension is kept only if
+ * it was written.
+ */
+func (vault *ObsidianVault) movedLinkTarget(source string, link *Link, target string, names map[string]int) string {
+	keepExt := strings.HasSuffix(strings.ToLower(link.Target), ".md")
+	trim := func(value string) string {
+		if keepExt {
+			return value
+		}
+		return strings.TrimSuffix(value, ".md")
+	}
+
+	if link.Markdown {
+		relpath, err := filepath.Rel(filepath.Dir(source), target)
+		if err != nil {
+			relpath = vault.RelativePath(target)
+		}
+
+		return trim(filepath.ToSlash(relpath))
+	}
+
+	base := filepath.Base(target)
+	if !strings.Contains(link.Target, "/") && names[strings.ToLower(base)] < 2 {
+		return trim(base)
+	}
+
+	return trim(filepath.ToSlash(vault.RelativePath(target)))
+}
+
+/*
+ * Move files, rewriting every link that resolves to them across the vault,
+ * and relative links out of moved notes. Links are rewritten before files
+ * move, so notes that are both moved and linking are updated in place first.
+ */
+func (vault *ObsidianVault) MoveFiles(graph *LinkGraph, renames []*Rename, reason string) error {
+	targets := map[string]string{}
+	for _, rename := range renames {
+		targets[rename.From] = rename.To
+	}
+
+	// count basenames after the moves, to find ambiguous bare links
+	names := map[string]int{}
+	for _, fpath := range append(append([]string{}, graph.Notes...), graph.Attachments...) {
+		if target, ok := targets[fpath]; ok {
+			fpath = target
+		}
+
+		names[strings.ToLower(filepath.Base(fpath))]++
+	}
+
+	for _, source := range graph.Notes {
+		edits := []*linkEdit{}
+		newSource, sourceMoved := targets[source]
+		if !sourceMoved {
+			newSource = source
+		}
+
+		for _, resolved := range graph.Links[source] {
+			link := resolved.Link
+			if len(resolved.Matches) != 1 || link.Target == "" {
+				continue
+			}
+
+			target, targetMoved := targets[resolved.Matches[0]]
+			if !targetMoved {
+				target = resolved.Matches[0]
+			}
+
+			// relative links from a moved note break even if their target stays put
+			relative := link.Markdown || strings.HasPrefix(link.Target, ".")
+			if !targetMoved && !(sourceMoved && relative) {
+				continue
+			}
+
+			text := vault.movedLinkTarget(newSource, link, target, names)
+
+			replaced := replaceWikilinkTarget(link.Raw, text)
+			if link.Markdown {
+				replaced = replaceMarkdownTarget(link.Raw, text)
+			}
+
+			edits = append(edits, &linkEdit{link: link, replaced: replaced})
+		}
+
+		if len(edits) == 0 {
+			continue
+		}
+
+		content, err := vault.plan.ReadFile(source)
+		if err != nil {
+			return err
+		}
+
+		updated := applyLinkEdits(content, edits)
+		if string(updated) == string(content) {
+			continue
+		}
+
+		if err := vault.plan.WriteFile(source, updated, "links updated for moved files"); err != nil {
+			return err
+		}
+	}
+
+	for _, rename := range renames {
+		if err := vault.plan.Rename(rename.From, rename.To, reason); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * A path within the vault, given relative to the vault or as an absolute
+ * path. Paths outside the vault are rejected.
+ */
+func (vault *ObsidianVault) vaultPath(fpath string) (string, error) {
+	relpath := filepath.Clean(fpath)
+
+	if filepath.IsAbs(fpath) {
+		dpath, err := filepath.Abs(vault.dpath)
+		if err != nil {
+			return "", err
+		}
+
+		relpath, err = filepath.Rel(dpath, fpath)
+		if err != nil {
+			return "", errors.Wrap(err, fpath+" is not in the vault")
+		}
+	}
+
+	if relpath == "." || relpath == ".." || strings.HasPrefix(relpath, ".."+string(filepath.Separator)) {
+		return "", errors.New(fpath + " is not in the vault")
+	}
+
+	return filepath.Join(vault.dpath, relpath), nil
+}
+
+/*
+ * Move a note or attachment within the vault, rewriting every wikilink,
+ * embed and markdown link to it. Moving into an existing folder keeps the
+ * file's name, and notes may be named without their `.md` extension.
+ * Returns the new path.
+ */
+func (vault *ObsidianVault) MoveFile(from string, to string) (string, error) {
+	source, err := vault.vaultPath(from)
+	if err != nil {
+		return "", err
+	}
+
+	// notes may be named without their extension, as in links
+	if _, err := os.Stat(source); errors.Is(err, os.ErrNotExist) && filepath.Ext(source) != ".md" {
+		source += ".md"
+	}
+
+	target, err := vault.vaultPath(to)
+	if err != nil {
+		return "", err
+	}
+
+	if info, err := os.Stat(target); (err == nil && info.IsDir()) || strings.HasSuffix(to, "/") {
+		target = filepath.Join(target, filepath.Base(source))
+	} else if filepath.Ext(target) == "" && filepath.Ext(source) == ".md" {
+		target += ".md"
+	}
+
+	if target == source {
+		return "", errors.New(from + " is already at " + to)
+	}
+
+	graph, err := vault.NewLinkGraph()
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := graph.Inbound[source]; !ok {
+		return "", errors.New(from + " is not a file in the vault")
+	}
+
+	return target, vault.MoveFiles(graph, []*Rename{{From: source, To: target}}, "moved by opal mv")
+}
@@ -0,0 +1,69 @@
+package opal
+
+import (
+	"testing"
+)
+
+func TestParseLinksColumns(t *testing.T) {
+	links := ParseLinks([]byte("`[[Hello]]` [[Hello]] and [[Hello]]\n"))
+
+	if len(links) != 2 {
+		t.Fatalf("expected 2 links outside inline code, got %d", len(links))
+	}
+
+	if links[0].Column != 12 || links[1].Column != 26 {
+		t.Errorf("unexpected columns %d and %d", links[0].Column, links[1].Column)
+	}
+}
+
+func TestMoveFileRewritesLinks(t *testing.T) {
+	vault := newTestVault(t, map[string]string{
+		"20220101 - Hello.md": "# Hello\n",
+		"Index.md": "`[[20220101 - Hello]]` [[20220101 - Hello]]\n" +
+			"[[20220101 - Hello#Intro|hi]] [[20220101 - Hello]] ![[20220101 - Hello]]\n" +
+			"| [[20220101 - Hello\\|table]] | [md](20220101%20-%20Hello.md) |\n" +
+			"```\n[[20220101 - Hello]]\n```\n",
+	})
+
+	if _, err := vault.MoveFile("20220101 - Hello.md", "notes/20220102 - Goodbye"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "`[[20220101 - Hello]]` [[20220102 - Goodbye]]\n" +
+		"[[20220102 - Goodbye#Intro|hi]] [[20220102 - Goodbye]] ![[20220102 - Goodbye]]\n" +
+		"| [[20220102 - Goodbye\\|table]] | [md](notes/20220102%20-%20Goodbye.md) |\n" +
+		"```\n[[20220101 - Hello]]\n```\n"
+
+	if actual := readTestFile(t, vault, "Index.md"); actual != expected {
+		t.Errorf("unexpected links after move:\n%s", actual)
+	}
+
+	readTestFile(t, vault, "notes/20220102 - Goodbye.md")
+}
+
+func TestMoveFileRewritesRelativeLinks(t *testing.T) {
+	vault := newTestVault(t, map[string]string{
+		"Other.md": "# Other\n",
+		"Moved.md": "[other](Other.md) [[Other]]\n",
+	})
+
+	if _, err := vault.MoveFile("Moved.md", "archive/"); err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := readTestFile(t, vault, "archive/Moved.md"); actual != "[other](../Other.md) [[Other]]\n" {
+		t.Errorf("unexpected links after move:\n%s", actual)
+	}
+}
+
+func TestMoveFileRejectsPathsOutsideVault(t *testing.T) {
+	vault := newTestVault(t, map[string]string{"Note.md": ""})
+
+	if _, err := vault.MoveFile("Note.md", "../Note.md"); err == nil {
+		t.Error("expected an error moving outside the vault")
+	}
+
+	if _, err := vault.MoveFile("Missing.md", "Other.md"); err == nil {
+		t.Error("expected an error moving a missing note")
+	}
+}
@@ -0,0 +1,210 @@
+package opal
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+const (
+	FormatText  = "text"
+	FormatJson  = "json"
+	FormatJunit = "junit"
+)
+
+/*
+ * A problem found by a validation check
+ */
+type Finding struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	File     string `json:"file"`
+	Message  string `json:"message"`
+}
+
+/*
+ * The checks run against a vault, and their findings
+ */
+type ValidationReport struct {
+	Checks   []string   `json:"checks"`
+	Findings []*Finding `json:"findings"`
+	Errors   int        `json:"errors"`
+	Warnings int        `json:"warnings"`
+}
+
+/*
+ * Construct an empty report
+ *
+ */
+func NewValidationReport() *ValidationReport {
+	return &ValidationReport{Checks: []string{}, Findings: []*Finding{}}
+}
+
+/*
+ * Record that a check ran
+ *
+ */
+func (report *ValidationReport) AddCheck(check string) {
+	report.Checks = append(report.Checks, check)
+}
+
+/*
+ * Record a finding
+ *
+ */
+func (report *ValidationReport) Add(check string, severity string, fpath string, message string) {
+	report.Findings = append(report.Findings, &Finding{
+		Check:    check,
+		Severity: severity,
+		File:     fpath,
+		Message:  message,
+	})
+
+	if severity == SeverityError {
+		report.Errors++
+	} else {
+		report.Warnings++
+	}
+}
+
+/*
+ * Findings from a single check
+ *
+ */
+func (report *ValidationReport) CheckFindings(check string) []*Finding {
+	findings := []*Finding{}
+
+	for _, finding := range report.Findings {
+		if finding.Check == check {
+			findings = append(findings, finding)
+		}
+	}
+
+	return findings
+}
+
+/*
+ * Print the report in text, JSON or JUnit XML format
+ *
+ */
+func (report *ValidationReport) Write(out io.Writer, format string) error {
+	switch format {
+	case "", FormatText:
+		return report.WriteText(out)
+	case FormatJson:
+		return report.WriteJson(out)
+	case FormatJunit:
+		return report.WriteJunit(out)
+	default:
+		return errors.New("unknown report format '" + format + "'; expected text, json or junit")
+	}
+}
+
+/*
+ * Print one line per finding, sorted by file, and a summary
+ *
+ */
+func (report *ValidationReport) WriteText(out io.Writer) error {
+	findings := append([]*Finding{}, report.Findings...)
+
+	sort.SliceStable(findings, func(idx, jdx int) bool {
+		return findings[idx].File < findings[jdx].File
+	})
+
+	for _, finding := range findings {
+		_, err := fmt.Fprintf(out, "%-8s %-12s %s: %s\n", finding.Severity, finding.Check, finding.File, finding.Message)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(out, "%d error(s), %d warning(s) from %d check(s)\n", report.Errors, report.Warnings, len(report.Checks))
+	return err
+}
+
+/*
+ * Print the report as indented JSON
+ *
+ */
+func (report *ValidationReport) WriteJson(out io.Writer) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(report)
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name         `xml:"testsuite"`
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	TestCases []*junitTestCase `xml:"testcase"`
+}
+
+/*
+ * Print the report as JUnit XML. Each check is a test-case that passes
+ * without findings; each finding is a test-case, failing for errors and
+ * passing with output for warnings.
+ */
+func (report *ValidationReport) WriteJunit(out io.Writer) error {
+	suite := &junitTestSuite{Name: "opal validate", TestCases: []*junitTestCase{}}
+
+	for _, check := range report.Checks {
+		findings := report.CheckFindings(check)
+
+		if len(findings) == 0 {
+			suite.TestCases = append(suite.TestCases, &junitTestCase{Name: check, Classname: "opal." + check})
+		}
+
+		for _, finding := range findings {
+			testCase := &junitTestCase{Name: finding.File, Classname: "opal." + check}
+
+			if finding.Severity == SeverityError {
+				testCase.Failure = &junitFailure{Message: finding.Message, Type: finding.Severity, Text: finding.Message}
+				suite.Failures++
+			} else {
+				testCase.SystemOut = finding.Severity + ": " + finding.Message
+			}
+
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+	}
+
+	suite.Tests = len(suite.TestCases)
+
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "  ")
+
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(out, "\n")
+	return err
+}
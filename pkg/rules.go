@@ -0,0 +1,251 @@
+package opal
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * A site-specific rule rewriting bookmark descriptions. Rules apply to URLs
+ * on a host, or its subdomains.
+ *
+ * Title rules replace every match of the pattern in the description. Path
+ * rules match the URL path, and on a match the replacement becomes the
+ * description. Replacements may use regexp groups (`$1`) and the `{title}`,
+ * `{host}` and `{time}` placeholders. Tags are added to matching bookmarks.
+ */
+type DescriptionRule struct {
+	Host    string   `yaml:"host"`
+	Field   string   `yaml:"field"`
+	Match   string   `yaml:"match"`
+	Replace string   `yaml:"replace"`
+	Tags    []string `yaml:"tags"`
+	pattern *regexp.Regexp
+}
+
+/*
+ * An ordered list of description rules
+ */
+type RuleSet struct {
+	Builtin bool               `yaml:"builtin"`
+	Rules   []*DescriptionRule `yaml:"rules"`
+}
+
+/*
+ * The result of applying rules to a bookmark
+ */
+type RuleResult struct {
+	Description string
+	Tags        []string
+	Matched     []*DescriptionRule
+}
+
+/*
+ * The built-in rules, used by bookmarks without configured rules
+ */
+var defaultRuleSet = NewBuiltinRuleSet()
+
+/*
+ * Rules for common sites with noisy titles
+ */
+func BuiltinRules() []*DescriptionRule {
+	return []*DescriptionRule{
+		{Host: "twitter.com", Field: "path", Match: `^/([^/]+)`, Replace: "Tweet from $1 on {time}", Tags: []string{"tweet"}},
+		{Host: "x.com", Field: "path", Match: `^/([^/]+)`, Replace: "Tweet from $1 on {time}", Tags: []string{"tweet"}},
+		{Host: "wikipedia.org", Match: ` - Wikipedia$`, Replace: ""},
+		{Host: "youtube.com", Match: ` - YouTube$`, Replace: "", Tags: []string{"video"}},
+		{Host: "youtu.be", Match: ` - YouTube$`, Replace: "", Tags: []string{"video"}},
+		{Host: "news.ycombinator.com", Match: ` \| Hacker News$`, Replace: ""},
+		{Host: "reddit.com", Match: `(?i)\s*(?:[:|-]\s*r/\w+|\|\s*reddit)$`, Replace: ""},
+		{Host: "arxiv.org", Match: `^\[[0-9.]+(?:v[0-9]+)?\]\s*`, Replace: "", Tags: []string{"paper"}},
+		{Host: "github.com", Match: `^GitHub - ([^:]+): (.*)$`, Replace: "$1 - $2"},
+		{Host: "stackoverflow.com", Match: ` - Stack Overflow$`, Replace: ""},
+	}
+}
+
+/*
+ * Compile each rule's pattern
+ *
+ */
+func (rules *RuleSet) compile() error {
+	for _, rule := range rules.Rules {
+		if rule.Field == "" {
+			rule.Field = "title"
+		}
+
+		if rule.Field != "title" && rule.Field != "path" {
+			return errors.New("rule for " + rule.Host + " has unknown field '" + rule.Field + "'; expected title or path")
+		}
+
+		pattern, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return errors.Wrap(err, "invalid pattern in rule for "+rule.Host)
+		}
+
+		rule.pattern = pattern
+	}
+
+	return nil
+}
+
+/*
+ * Construct a rule-set from the built-in rules
+ *
+ */
+func NewBuiltinRuleSet() *RuleSet {
+	rules := &RuleSet{Builtin: true, Rules: BuiltinRules()}
+
+	// built-in rules are known to compile
+	if err := rules.compile(); err != nil {
+		panic(err)
+	}
+
+	return rules
+}
+
+/*
+ * Rule files searched in priority order; the first that exists is used
+ *
+ */
+func RulesSearchPath(rulesFile string, vaultDir string) []string {
+	fpaths := []string{}
+
+	if rulesFile != "" {
+		fpaths = append(fpaths, rulesFile)
+	}
+
+	if vaultDir != "" {
+		fpaths = append(fpaths, filepath.Join(vaultDir, ".opal", "rules.yaml"))
+	}
+
+	if configDir, err := os.UserConfigDir(); err == nil {
+		fpaths = append(fpaths, filepath.Join(configDir, "opal", "rules.yaml"))
+	}
+
+	return fpaths
+}
+
+/*
+ * Load rules from the first rule file found. User rules run before the
+ * built-in rules, which can be disabled with `builtin: false`.
+ */
+func LoadRules(fpaths []string) (*RuleSet, error) {
+	for _, fpath := range fpaths {
+		content, err := os.ReadFile(fpath)
+
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return ParseRules(content)
+	}
+
+	return NewBuiltinRuleSet(), nil
+}
+
+/*
+ * Parse a YAML rule file
+ *
+ */
+func ParseRules(content []byte) (*RuleSet, error) {
+	rules := &RuleSet{Builtin: true}
+
+	if err := yaml.Unmarshal(content, rules); err != nil {
+		return nil, errors.Wrap(err, "failed parsing rules")
+	}
+
+	if rules.Builtin {
+		rules.Rules = append(rules.Rules, BuiltinRules()...)
+	}
+
+	if err := rules.compile(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+/*
+ * Does a rule apply to a host? Rules also match subdomains.
+ *
+ */
+func (rule *DescriptionRule) AppliesTo(host string) bool {
+	host = strings.ToLower(host)
+	ruleHost := strings.ToLower(strings.TrimPrefix(rule.Host, "*."))
+
+	return host == ruleHost || strings.HasSuffix(host, "."+ruleHost)
+}
+
+/*
+ * Apply every matching rule, in order, to a bookmark's description
+ *
+ */
+func (rules *RuleSet) Apply(href string, description string, time string) *RuleResult {
+	result := &RuleResult{Description: description, Tags: []string{}}
+
+	parsed, err := url.Parse(href)
+	if err != nil {
+		return result
+	}
+
+	for _, rule := range rules.Rules {
+		if !rule.AppliesTo(parsed.Hostname()) {
+			continue
+		}
+
+		// escape placeholder values, so they are not expanded as regexp groups
+		replace := strings.NewReplacer(
+			"{title}", strings.ReplaceAll(result.Description, "$", "$$"),
+			"{host}", strings.ReplaceAll(parsed.Hostname(), "$", "$$"),
+			"{time}", strings.ReplaceAll(time, "$", "$$")).Replace(rule.Replace)
+
+		if rule.Field == "path" {
+			match := rule.pattern.FindStringSubmatchIndex(parsed.Path)
+			if match == nil {
+				continue
+			}
+
+			result.Description = string(rule.pattern.ExpandString(nil, replace, parsed.Path, match))
+		} else {
+			if !rule.pattern.MatchString(result.Description) {
+				continue
+			}
+
+			result.Description = strings.TrimSpace(rule.pattern.ReplaceAllString(result.Description, replace))
+		}
+
+		result.Matched = append(result.Matched, rule)
+		result.Tags = append(result.Tags, rule.Tags...)
+	}
+
+	return result
+}
+
+/*
+ * Print the rewritten description and tags for a URL and title
+ *
+ */
+func TestRules(out io.Writer, rules *RuleSet, href string, title string) error {
+	result := rules.Apply(href, title, "")
+
+	for _, rule := range result.Matched {
+		if _, err := fmt.Fprintf(out, "matched:     %s %s %q\n", rule.Host, rule.Field, rule.Match); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(out, "description: %s\ntags:        %s\n", result.Description, strings.Join(result.Tags, ", "))
+	return err
+}
@@ -0,0 +1,29 @@
+package opal
+
+import (
+	"testing"
+)
+
+func TestBuiltinRules(t *testing.T) {
+	rules := NewBuiltinRuleSet()
+	cases := []struct {
+		href     string
+		title    string
+		expected string
+	}{
+		{"https://twitter.com/rgrannell1/status/1", "Róisín on Twitter: \"...\"", "Tweet from rgrannell1 on 2022-01-01"},
+		{"https://x.com/rgrannell1/status/1", "Róisín on X: \"...\"", "Tweet from rgrannell1 on 2022-01-01"},
+		{"https://mobile.x.com/rgrannell1/status/1", "Róisín on X: \"...\"", "Tweet from rgrannell1 on 2022-01-01"},
+		{"https://en.wikipedia.org/wiki/Go", "Go - Wikipedia", "Go"},
+		{"https://github.com/rgrannell1/opal", "GitHub - rgrannell1/opal: Obsidian notes", "rgrannell1/opal - Obsidian notes"},
+		{"https://example.com", "Example - Wikipedia", "Example - Wikipedia"},
+	}
+
+	for _, testCase := range cases {
+		result := rules.Apply(testCase.href, testCase.title, "2022-01-01")
+
+		if result.Description != testCase.expected {
+			t.Errorf("Apply(%q) = %q, expected %q", testCase.href, result.Description, testCase.expected)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package opal
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CheckFrontmatter = "frontmatter"
+	CheckSchema      = "schema"
+)
+
+/*
+ * Layouts accepted for ISO dates in frontmatter
+ */
+var isoDateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+}
+
+/*
+ * Frontmatter requirements for notes in a folder, or with a tag. Fields
+ * map keys to a type: string, list, number, bool or date.
+ */
+type FrontmatterSchema struct {
+	Folder   string            `yaml:"folder"`
+	Tag      string            `yaml:"tag"`
+	Required []string          `yaml:"required"`
+	Fields   map[string]string `yaml:"fields"`
+}
+
+/*
+ * Describe which notes a schema applies to
+ *
+ */
+func (schema *FrontmatterSchema) String() string {
+	if schema.Folder != "" {
+		return "folder " + schema.Folder
+	}
+
+	return "tag #" + schema.Tag
+}
+
+/*
+ * Check the schema is usable
+ *
+ */
+func (schema *FrontmatterSchema) Validate() error {
+	if schema.Folder == "" && schema.Tag == "" {
+		return errors.New("frontmatter schema needs a folder or a tag")
+	}
+
+	for key, kind := range schema.Fields {
+		switch kind {
+		case "string", "list", "number", "bool", "date":
+		default:
+			return errors.New("frontmatter schema for " + schema.String() + " gives " + key + " unknown type '" + kind + "'; expected string, list, number, bool or date")
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Does a schema apply to a note? Tags match nested tags too, so `book`
+ * applies to notes tagged `book/fiction`.
+ */
+func (schema *FrontmatterSchema) AppliesTo(vault *ObsidianVault, fpath string, fm *FrontmatterDocument) bool {
+	if schema.Folder != "" && !vault.InFolders(fpath, []string{schema.Folder}) {
+		return false
+	}
+
+	if schema.Tag == "" {
+		return true
+	}
+
+	tag := strings.ToLower(strings.TrimPrefix(schema.Tag, "#"))
+	for _, noteTag := range fm.GetList("tags") {
+		noteTag = strings.ToLower(strings.TrimPrefix(noteTag, "#"))
+
+		if noteTag == tag || strings.HasPrefix(noteTag, tag+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Is a value an ISO date or date-time?
+ *
+ */
+func isIsoDate(value string) bool {
+	for _, layout := range isoDateLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Does a frontmatter value have the expected type?
+ *
+ */
+func hasFieldType(node *yaml.Node, kind string) bool {
+	switch kind {
+	case "list":
+		return node.Kind == yaml.SequenceNode
+	case "string":
+		return node.Kind == yaml.ScalarNode
+	case "number":
+		return node.Kind == yaml.ScalarNode && (node.Tag == "!!int" || node.Tag == "!!float")
+	case "bool":
+		return node.Kind == yaml.ScalarNode && node.Tag == "!!bool"
+	case "date":
+		return node.Kind == yaml.ScalarNode && isIsoDate(node.Value)
+	}
+
+	return false
+}
+
+/*
+ * Check a note's frontmatter against a schema
+ *
+ */
+func (schema *FrontmatterSchema) Check(fpath string, fm *FrontmatterDocument, report *ValidationReport) {
+	for _, key := range schema.Required {
+		node := fm.Get(key)
+
+		if node == nil || (node.Kind == yaml.ScalarNode && (node.Tag == "!!null" || node.Value == "")) {
+			report.Add(CheckSchema, SeverityError, fpath, key+" is required for notes in "+schema.String())
+		}
+	}
+
+	keys := []string{}
+	for key := range schema.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		node := fm.Get(key)
+		if node == nil || node.Tag == "!!null" {
+			continue
+		}
+
+		if !hasFieldType(node, schema.Fields[key]) {
+			report.Add(CheckSchema, SeverityError, fpath, fmt.Sprintf("%s should be a %s for notes in %s", key, schema.Fields[key], schema.String()))
+		}
+	}
+}
+
+/*
+ * Check every note's frontmatter parses, and matches the schemas that
+ * apply to it. Notes are read from disk, since unparseable frontmatter
+ * never reaches the index.
+ */
+func CheckFrontmatterSchemas(vault *ObsidianVault, schemas []*FrontmatterSchema, report *ValidationReport) error {
+	for _, schema := range schemas {
+		if err := schema.Validate(); err != nil {
+			return err
+		}
+	}
+
+	notes, err := vault.ListMarkdown()
+	if err != nil {
+		return err
+	}
+
+	for _, fpath := range notes {
+		content, err := os.ReadFile(fpath)
+		if err != nil {
+			return err
+		}
+
+		block, _ := SplitFrontmatter(content)
+		fm, err := ParseFrontmatterDocument(block)
+		if err != nil {
+			report.Add(CheckFrontmatter, SeverityError, fpath, "frontmatter does not parse: "+err.Error())
+			continue
+		}
+
+		for _, schema := range schemas {
+			if schema.AppliesTo(vault, fpath, fm) {
+				schema.Check(fpath, fm, report)
+			}
+		}
+	}
+
+	return nil
+}
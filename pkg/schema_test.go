@@ -0,0 +1,61 @@
+package opal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFrontmatterSchemaValidate(t *testing.T) {
+	invalid := []*FrontmatterSchema{
+		{Required: []string{"title"}},
+		{Folder: "books", Fields: map[string]string{"rating": "stars"}},
+	}
+
+	for _, schema := range invalid {
+		if err := schema.Validate(); err == nil {
+			t.Errorf("expected schema %+v to be invalid", schema)
+		}
+	}
+}
+
+func TestCheckFrontmatterSchemas(t *testing.T) {
+	vault := newTestVault(t, map[string]string{
+		"books/Good.md":     "---\ntitle: Good\nrating: 4\nread: 2022-01-01\ntags: [book/fiction]\n---\n",
+		"books/Bad.md":      "---\nrating: lots\nread: yesterday\n---\n",
+		"notes/Tagged.md":   "---\ntags: [book]\nfinished: maybe\n---\n",
+		"notes/Untagged.md": "---\nfinished: maybe\n---\n",
+		"notes/Broken.md":   "---\ntitle: [unclosed\n---\n",
+	})
+
+	schemas := []*FrontmatterSchema{
+		{Folder: "books", Required: []string{"title"}, Fields: map[string]string{"rating": "number", "read": "date"}},
+		{Tag: "book", Fields: map[string]string{"finished": "bool"}},
+	}
+
+	report := NewValidationReport()
+	if err := CheckFrontmatterSchemas(vault, schemas, report); err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string][]string{}
+	for _, finding := range report.Findings {
+		relpath := vault.RelativePath(finding.File)
+		found[relpath] = append(found[relpath], finding.Message)
+	}
+
+	if len(found["books/Good.md"]) != 0 || len(found["notes/Untagged.md"]) != 0 {
+		t.Errorf("unexpected findings %v", found)
+	}
+
+	if len(found["books/Bad.md"]) != 3 {
+		t.Errorf("expected a missing title, and bad rating and read types, got %v", found["books/Bad.md"])
+	}
+
+	if len(found["notes/Tagged.md"]) != 1 || !strings.Contains(found["notes/Tagged.md"][0], "finished should be a bool") {
+		t.Errorf("expected a bad finished type, got %v", found["notes/Tagged.md"])
+	}
+
+	if len(found["notes/Broken.md"]) != 1 || !strings.Contains(found["notes/Broken.md"][0], "does not parse") {
+		t.Errorf("expected unparseable frontmatter to be reported, got %v", found["notes/Broken.md"])
+	}
+}
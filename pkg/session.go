@@ -0,0 +1,410 @@
+package opal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * State shared by the steps of a run: the vault, the database, and the plan
+ * of changes. Subcommands run only the steps they need.
+ */
+type Session struct {
+	args     *OpalArgs
+	dbpath   string
+	plan     *Plan
+	vault    *ObsidianVault
+	conn     *OpalDb
+	indexer  Indexer
+	prefixes *TagPrefixes
+	// notes fixed this run, marked as processed by Finish
+	notes []*ObsidianNote
+}
+
+/*
+ * Construct a session, opening the database and creating Opal's tables
+ *
+ */
+func NewSession(args *OpalArgs) (*Session, error) {
+	if args.Fpath == "" {
+		return nil, errors.New("no vault given; pass <fpath>, set OPAL_VAULT, or set vault in opal.yaml")
+	}
+
+	if args.Audit && args.Fix {
+		return nil, errors.New("--audit and --fix cannot be used together")
+	}
+
+	// reject typos before any work starts, not once an orphan is found
+	if err := ValidateOrphanMode(args.Orphans); err != nil {
+		return nil, err
+	}
+
+	// the index holds absolute paths, so `opal fix .` matches its own rows
+	fpath, err := filepath.Abs(args.Fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	vaultArgs := *args
+	vaultArgs.Fpath = fpath
+	args = &vaultArgs
+
+	prefixes := args.Prefixes
+	if prefixes == nil {
+		prefixes = DefaultTagPrefixes()
+	}
+
+	dbpath, err := DatabasePath(args.Db, args.Fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	// the default database lives in the vault's `.opal` folder
+	if err := os.MkdirAll(filepath.Dir(dbpath), 0755); err != nil {
+		return nil, err
+	}
+
+	indexer := args.Indexer
+	if indexer == nil {
+		indexer, err = NewIndexer(args.Index)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := NewOpalDb(dbpath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.CreateTables(); err != nil {
+		return nil, err
+	}
+
+	plan := NewPlan(args.Audit || !args.Fix, args.Fpath)
+
+	return &Session{
+		args:     args,
+		dbpath:   dbpath,
+		plan:     plan,
+		vault:    NewObsidianVault(args.Fpath, plan),
+		conn:     conn,
+		indexer:  indexer,
+		prefixes: prefixes,
+		notes:    []*ObsidianNote{},
+	}, nil
+}
+
+/*
+ * Index the vault into the database
+ *
+ */
+func (session *Session) Index() error {
+	return session.indexer.Index(session.args.Fpath, session.dbpath)
+}
+
+/*
+ * Fetch bookmarks and stars, unless offline
+ *
+ */
+func (session *Session) Fetch() error {
+	fetcher := session.args.Fetcher
+	if fetcher == nil {
+		fetcher = DefaultFetcher(session.args.Offline)
+	}
+
+	return fetcher.Fetch(session.dbpath)
+}
+
+/*
+ * Fix the frontmatter and titles of notes modified since the last run
+ *
+ */
+func (session *Session) FixNotes() error {
+	notes, err := session.vault.ListModifiedMarkdown(session.conn, session.args.Force)
+	if err != nil {
+		return err
+	}
+
+	// notes with malformed frontmatter are skipped, and retried next run
+	notes, err = session.vault.FixFrontmatter(os.Stdout, notes, session.conn)
+	if err != nil {
+		return err
+	}
+
+	if err := session.vault.FixTitle(notes, session.conn); err != nil {
+		return err
+	}
+
+	session.notes = append(session.notes, notes...)
+
+	return nil
+}
+
+/*
+ * The sources to sync, using the configured description rules
+ *
+ */
+func (session *Session) Sources() ([]Source, error) {
+	rules, err := LoadRules(RulesSearchPath(session.args.Rules, session.args.Fpath))
+	if err != nil {
+		return nil, err
+	}
+
+	return DefaultSources(&SourceOptions{
+		Rules:           rules,
+		MergeDuplicates: session.args.Merge,
+		Folders:         session.args.Folders,
+	}), nil
+}
+
+/*
+ * Sync and optionally reconcile each source, then handle notes whose
+ * records were deleted upstream
+ */
+func (session *Session) Sync(sources []Source) error {
+	sources, err := session.fetchedSources(os.Stdout, sources)
+	if err != nil {
+		return err
+	}
+
+	dirs := TemplateSearchPath(session.args.Templates, session.args.Fpath)
+
+	for _, source := range sources {
+		tmpl, _, err := LoadSourceTemplate(source, dirs)
+		if err != nil {
+			return err
+		}
+
+		err = SyncSource(source, tmpl, session.vault, session.conn, session.prefixes)
+		if err != nil {
+			return err
+		}
+
+		if session.args.Reconcile {
+			err = ReconcileSource(source, tmpl, session.vault, session.conn, session.prefixes)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// bookmarks and stars deleted upstream leave orphaned notes behind
+	return HandleOrphans(os.Stdout, session.vault, session.conn, sources, session.args.Orphans)
+}
+
+/*
+ * The sources coppermind has fetched into the database. Offline runs skip
+ * unfetched sources with a notice, so local fixes still complete; otherwise
+ * a missing table fails the run.
+ */
+func (session *Session) fetchedSources(out io.Writer, sources []Source) ([]Source, error) {
+	fetched := []Source{}
+
+	for _, source := range sources {
+		_, err := source.List(session.conn)
+		if errors.Is(err, ErrMissingTable) && session.args.Offline {
+			fmt.Fprintf(out, "skipping %ss: not fetched yet, and --offline skips coppermind\n", source.Name())
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		fetched = append(fetched, source)
+	}
+
+	return fetched, nil
+}
+
+/*
+ * Re-index after Opal's changes and mark fixed notes as processed, or in
+ * audit mode, report the changes that would have been made
+ */
+func (session *Session) Finish() error {
+	if !session.plan.DryRun {
+		if err := session.Index(); err != nil {
+			return err
+		}
+
+		// store post-fix hashes, so unchanged notes are skipped next run
+		if err := session.conn.MarkComplete(session.notes); err != nil {
+			return err
+		}
+	}
+
+	if session.args.Audit {
+		return session.plan.Report(os.Stdout, session.vault)
+	}
+
+	return nil
+}
+
+/*
+ * The configured validation checks, excluding the folders Opal generates
+ * and archives notes in
+ */
+func (session *Session) checks() (*ValidationConfig, error) {
+	checks := DefaultConfig().Validation
+	if session.args.Validation != nil {
+		checks = *session.args.Validation
+	}
+
+	sources, err := session.Sources()
+	if err != nil {
+		return nil, err
+	}
+
+	// generated and archived notes are linked to, but rarely link out
+	checks.Exclude = append([]string{ArchiveFolder}, checks.Exclude...)
+	for _, source := range sources {
+		checks.Exclude = append(checks.Exclude, source.Folder())
+	}
+
+	return &checks, nil
+}
+
+/*
+ * Check the vault for problems, and print a report. Only errors fail
+ * validation; warnings are reported.
+ */
+func (session *Session) Validate() error {
+	checks, err := session.checks()
+	if err != nil {
+		return err
+	}
+
+	report, err := session.vault.Validate(session.conn, checks)
+	if err != nil {
+		return err
+	}
+
+	if err := report.Write(os.Stdout, session.args.Format); err != nil {
+		return err
+	}
+
+	if report.Errors > 0 {
+		return errors.New(fmt.Sprint(report.Errors) + " validation error(s) found")
+	}
+
+	return nil
+}
+
+/*
+ * Summarise the index, notes awaiting fixes, and each source's records
+ *
+ */
+func (session *Session) Status(out io.Writer, sources []Source) error {
+	fmt.Fprintf(out, "%-20s %s\n", "database", session.dbpath)
+
+	// a fresh database has no index until the first run
+	indexed, err := session.conn.HasTable("file")
+	if err != nil {
+		return err
+	}
+
+	if !indexed {
+		_, err = fmt.Fprintf(out, "%-20s not indexed; run opal index\n", "indexed")
+		return err
+	}
+
+	hashes, err := session.conn.ListHashes(session.vault.dpath)
+	if err != nil {
+		return err
+	}
+
+	modified, err := session.vault.ListModifiedMarkdown(session.conn, false)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%-20s %d note(s)\n", "indexed", len(hashes))
+	fmt.Fprintf(out, "%-20s %d note(s)\n", "awaiting fixes", len(modified))
+
+	fetched := []Source{}
+	for _, source := range sources {
+		notes, err := GetIdentityNotes(session.conn, session.vault.dpath, source.Key())
+		if err != nil {
+			return err
+		}
+
+		records, err := source.List(session.conn)
+		if errors.Is(err, ErrMissingTable) {
+			fmt.Fprintf(out, "%-20s not fetched\n", source.Name())
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		fetched = append(fetched, source)
+
+		unsynced := 0
+		for _, record := range records {
+			for _, identity := range RecordIdentities(record) {
+				if _, ok := notes[identity]; !ok {
+					unsynced++
+					break
+				}
+			}
+		}
+
+		fmt.Fprintf(out, "%-20s %d record(s), %d not yet synced\n", source.Name(), len(records), unsynced)
+	}
+
+	orphans, err := ListOrphanedNotes(session.conn, session.vault.dpath, fetched)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "%-20s %d note(s)\n", "orphaned", len(orphans))
+	return err
+}
+
+/*
+ * Print notes whose filenames break the naming convention
+ *
+ */
+func (session *Session) ListFilenames() error {
+	checks, err := session.checks()
+	if err != nil {
+		return err
+	}
+
+	report := NewValidationReport()
+	report.AddCheck(CheckFilenames)
+
+	if err := CheckNoBadFilenames(session.vault, checks.Exclude, report); err != nil {
+		return err
+	}
+
+	return report.Write(os.Stdout, session.args.Format)
+}
+
+/*
+ * Rename notes whose filenames break the naming convention, rewriting
+ * links to them
+ */
+func (session *Session) FixFilenames() error {
+	checks, err := session.checks()
+	if err != nil {
+		return err
+	}
+
+	return session.vault.FixFilenames(checks.Exclude)
+}
+
+/*
+ * Move a file within the vault, rewriting links to it
+ *
+ */
+func (session *Session) Move(from string, to string) error {
+	_, err := session.vault.MoveFile(from, to)
+	return err
+}
@@ -0,0 +1,98 @@
+package opal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNewSessionRejectsUnknownOrphanMode(t *testing.T) {
+	vault := newTestVault(t, map[string]string{})
+
+	_, dbpath := newTestDb(t)
+
+	_, err := NewSession(&OpalArgs{Fpath: vault.dpath, Orphans: "archve", Db: dbpath})
+	if err == nil {
+		t.Fatal("expected an unknown orphan mode to be rejected")
+	}
+
+	for _, mode := range []string{"", OrphanReport, OrphanTag, OrphanArchive} {
+		if err := ValidateOrphanMode(mode); err != nil {
+			t.Errorf("expected %q to be valid, got %v", mode, err)
+		}
+	}
+}
+
+/*
+ * A session over a temporary vault and database, isolated from the
+ * user's configuration
+ */
+func newTestSession(t *testing.T, files map[string]string, args *OpalArgs) *Session {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	vault := newTestVault(t, files)
+	_, dbpath := newTestDb(t)
+
+	args.Fpath = vault.dpath
+	args.Db = dbpath
+	args.Indexer = &BuiltinIndexer{}
+
+	session, err := NewSession(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.conn.Db.Close() })
+
+	return session
+}
+
+func TestStatusBeforeIndexing(t *testing.T) {
+	session := newTestSession(t, map[string]string{"20220101 - Note.md": "# Note\n"}, &OpalArgs{Fix: true})
+
+	sources, err := session.Sources()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(bytes.Buffer)
+	if err := session.Status(out, sources); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "not indexed") {
+		t.Errorf("expected an unindexed database to be reported, got:\n%s", out.String())
+	}
+
+	if err := session.Index(); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	if err := session.Status(out, sources); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "1 note(s)") || !strings.Contains(out.String(), "not fetched") {
+		t.Errorf("expected indexed notes and unfetched sources to be reported, got:\n%s", out.String())
+	}
+}
+
+func TestFixRelativeVault(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	vault := newTestVault(t, map[string]string{"20220101 - Note.md": "Some prose\n"})
+	_, dbpath := newTestDb(t)
+	chdirTest(t, vault.dpath)
+
+	for run := 0; run < 2; run++ {
+		err := OpalFix(&OpalArgs{Fpath: ".", Fix: true, Db: dbpath, Indexer: &BuiltinIndexer{}})
+		if err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+	}
+
+	if content := readTestFile(t, vault, "20220101 - Note.md"); !strings.Contains(content, "# Note") {
+		t.Errorf("expected the title to be fixed, got %q", content)
+	}
+}
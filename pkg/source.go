@@ -0,0 +1,259 @@
+package opal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * A row from an external source, rendered into a single vault note
+ */
+type SourceRecord interface {
+	// the value stored under the source's frontmatter key
+	Identity() string
+	// the filename date prefix
+	Date() string
+	// the filename title
+	Title() (string, error)
+	// render the note; date is the note's full filename prefix
+	Render(tmpl *template.Template, date string, prefixes *TagPrefixes) ([]byte, error)
+}
+
+/*
+ * A record held by a note under several identities, such as duplicate
+ * bookmarks merged into one note
+ */
+type MultiIdentityRecord interface {
+	Identities() []string
+}
+
+/*
+ * Every identity a record's note holds
+ *
+ */
+func RecordIdentities(record SourceRecord) []string {
+	if multi, ok := record.(MultiIdentityRecord); ok {
+		return multi.Identities()
+	}
+
+	return []string{record.Identity()}
+}
+
+/*
+ * An external source synced into the vault. Each source owns a table
+ * query, an identity key stored in frontmatter, a template, a target
+ * folder and a fenced data block.
+ */
+type Source interface {
+	// the name selecting the source on the command line, like `bookmarks`
+	Kind() string
+	// a human-readable name for the source's records
+	Name() string
+	// the frontmatter key identifying which record a note holds
+	Key() string
+	// the vault folder new notes are written into
+	Folder() string
+	// the fenced-block info-string holding the record's data
+	Block() string
+	// the template filename, looked up on the template search path
+	TemplateFile() string
+	// the tag applied to notes whose record was deleted upstream
+	DeletedTag() string
+	// the prefixes of tags the source writes; reconciliation removes stale ones
+	TagPrefixes(prefixes *TagPrefixes) []string
+	ParseTemplate(content string) (*template.Template, error)
+	List(conn *OpalDb) ([]SourceRecord, error)
+	// sample records for checking templates without a database
+	Fixtures() []SourceRecord
+}
+
+/*
+ * Options for the default sources
+ */
+type SourceOptions struct {
+	// bookmark description rules; the built-in rules are used if nil
+	Rules *RuleSet
+	// write bookmarks sharing a canonical URL into a single note
+	MergeDuplicates bool
+	// vault folders for new notes; empty folders use each source's default
+	Folders FolderConfig
+}
+
+/*
+ * The sources Opal syncs by default. Nil options use the built-in
+ * description rules, and don't merge duplicate bookmarks.
+ */
+func DefaultSources(opts *SourceOptions) []Source {
+	if opts == nil {
+		opts = &SourceOptions{}
+	}
+
+	rules := opts.Rules
+	if rules == nil {
+		rules = defaultRuleSet
+	}
+
+	return []Source{
+		&PinboardSource{Rules: rules, MergeDuplicates: opts.MergeDuplicates, FolderName: opts.Folders.Bookmarks},
+		&GithubStarSource{FolderName: opts.Folders.Stars},
+	}
+}
+
+/*
+ * Map identities present in Obsidian frontmatter under a key to the note
+ * holding them, so we can efficiently find records that still need notes
+ */
+func GetIdentityNotes(conn *OpalDb, dir string, key string) (map[string]string, error) {
+	notes := map[string]string{}
+	frontmatter, err := GetFrontmatter(conn, dir)
+
+	if err != nil {
+		return notes, err
+	}
+
+	for _, fm := range frontmatter {
+		for _, identity := range fm.Values(key) {
+			notes[identity] = fm.FileId
+		}
+	}
+
+	return notes, nil
+}
+
+/*
+ * Write a record into a new note in the source's folder
+ *
+ */
+func (vault *ObsidianVault) WriteRecord(source Source, record SourceRecord, tmpl *template.Template, prefixes *TagPrefixes) error {
+	title, err := record.Title()
+	if err != nil {
+		return err
+	}
+
+	fpath, exists, err := vault.ChooseNotePath(source.Folder(), record.Date(), title, source.Key(), record.Identity())
+	if err != nil {
+		return err
+	}
+
+	// the note is already present, but was not yet indexed
+	if exists {
+		return nil
+	}
+
+	date := strings.SplitN(filepath.Base(fpath), " - ", 2)[0]
+
+	content, err := record.Render(tmpl, date, prefixes)
+	if err != nil {
+		return err
+	}
+
+	err = vault.plan.WriteFile(fpath, content, "new "+source.Name()+" "+record.Identity())
+	if err != nil {
+		return errors.Wrap(err, "failed writing "+source.Name()+" to file")
+	}
+
+	return nil
+}
+
+/*
+ * Add identities to an existing note's frontmatter list
+ *
+ */
+func (vault *ObsidianVault) AddIdentities(fpath string, key string, identities []string, reason string) error {
+	content, err := vault.plan.ReadFile(fpath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	updated, changed, err := MergeFrontmatter(content, &FrontmatterUpdate{
+		Lists: map[string][]string{key: identities},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed parsing frontmatter in "+fpath)
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	return vault.plan.WriteFile(fpath, updated, reason)
+}
+
+/*
+ * Sync a source into Obsidian, ensuring each record exists as a note
+ * with its identity in the frontmatter. Identities missing from a record's
+ * existing note are added to it, rather than creating a second note.
+ */
+func SyncSource(source Source, tmpl *template.Template, vault *ObsidianVault, conn *OpalDb, prefixes *TagPrefixes) error {
+	notes, err := GetIdentityNotes(conn, vault.dpath, source.Key())
+	if err != nil {
+		return err
+	}
+
+	records, err := source.List(conn)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		fpath := ""
+		missing := []string{}
+
+		for _, identity := range RecordIdentities(record) {
+			if note, ok := notes[identity]; !ok {
+				missing = append(missing, identity)
+			} else if fpath == "" {
+				fpath = note
+			}
+		}
+
+		if len(missing) == 0 {
+			continue
+		}
+
+		if fpath != "" {
+			reason := source.Key() + " " + strings.Join(missing, ", ") + " merged into note"
+			if err := vault.AddIdentities(fpath, source.Key(), missing, reason); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := vault.WriteRecord(source, record, tmpl, prefixes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Select sources by kind, such as `bookmarks` or `stars`
+ *
+ */
+func SelectSources(sources []Source, kind string) ([]Source, error) {
+	selected := []Source{}
+	kinds := []string{}
+
+	for _, source := range sources {
+		kinds = append(kinds, source.Kind())
+
+		if source.Kind() == kind {
+			selected = append(selected, source)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, errors.New("unknown source '" + kind + "'; expected one of " + strings.Join(kinds, ", "))
+	}
+
+	return selected, nil
+}
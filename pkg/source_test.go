@@ -0,0 +1,298 @@
+package opal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+ * Seeds coppermind's tables instead of fetching from Pinboard and GitHub
+ *
+ */
+type fakeFetcher struct {
+	calls     int
+	bookmarks [][]interface{}
+	stars     [][]interface{}
+}
+
+func (fetcher *fakeFetcher) Fetch(dbpath string) error {
+	fetcher.calls++
+
+	conn, err := NewOpalDb(dbpath)
+	if err != nil {
+		return err
+	}
+	defer conn.Db.Close()
+
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS pinboard_bookmark (description TEXT, extended TEXT, hash TEXT, href TEXT,
+			meta TEXT, shared TEXT, tags TEXT, time TEXT, toread TEXT)`,
+		`CREATE TABLE IF NOT EXISTS github_star (name TEXT, description TEXT, login TEXT, url TEXT,
+			language TEXT, topics TEXT)`,
+		`DELETE FROM pinboard_bookmark`,
+		`DELETE FROM github_star`,
+	}
+
+	for _, statement := range statements {
+		if _, err := conn.Db.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	for _, row := range fetcher.bookmarks {
+		if _, err := conn.Db.Exec(`INSERT INTO pinboard_bookmark VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, row...); err != nil {
+			return err
+		}
+	}
+
+	for _, row := range fetcher.stars {
+		if _, err := conn.Db.Exec(`INSERT INTO github_star VALUES (?, ?, ?, ?, ?, ?)`, row...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * The files in a vault folder
+ *
+ */
+func listTestFolder(t *testing.T, vault *ObsidianVault, folder string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(filepath.Join(vault.dpath, folder))
+	if os.IsNotExist(err) {
+		return []string{}
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names
+}
+
+func TestSyncWithFakeFetcher(t *testing.T) {
+	fetcher := &fakeFetcher{
+		bookmarks: [][]interface{}{
+			{"Go - Wikipedia", "", "abc123", "https://en.wikipedia.org/wiki/Go", "", "yes", "go ---", "2022-02-07T21:51:23Z", "no"},
+		},
+		stars: [][]interface{}{
+			{"rgrannell1/opal", "Fix Obsidian notes", "rgrannell1", "https://github.com/rgrannell1/opal", "Go", "obsidian"},
+		},
+	}
+
+	args := &OpalArgs{Fix: true, Fetcher: fetcher}
+	session := newTestSession(t, map[string]string{}, args)
+	vault := session.vault
+
+	for run := 0; run < 2; run++ {
+		if err := OpalSync(args, "bookmarks"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if fetcher.calls != 2 {
+		t.Errorf("expected the fetcher to run on each sync, ran %d time(s)", fetcher.calls)
+	}
+
+	names := listTestFolder(t, vault, "pinboard-bookmarks")
+	if len(names) != 1 || !strings.HasPrefix(names[0], "20220207") || !strings.HasSuffix(names[0], " - Go.md") {
+		t.Fatalf("expected one bookmark note, got %v", names)
+	}
+
+	content := readTestFile(t, vault, filepath.Join("pinboard-bookmarks", names[0]))
+	if !strings.Contains(content, "bookmark_hash: \"abc123\"") || !strings.Contains(content, "pinboard/go") {
+		t.Errorf("unexpected bookmark note:\n%s", content)
+	}
+
+	if strings.Contains(content, "- pinboard\n") || strings.Contains(content, "\"pinboard\"") {
+		t.Errorf("empty tags should not leave a bare prefix:\n%s", content)
+	}
+
+	if stars := listTestFolder(t, vault, "github-stars"); len(stars) != 0 {
+		t.Errorf("syncing bookmarks should not write stars, got %v", stars)
+	}
+}
+
+func TestSyncAuditWritesNothing(t *testing.T) {
+	fetcher := &fakeFetcher{
+		stars: [][]interface{}{
+			{"rgrannell1/opal", "Fix Obsidian notes", "rgrannell1", "https://github.com/rgrannell1/opal", "Go", "obsidian"},
+		},
+	}
+
+	args := &OpalArgs{Audit: true, Fetcher: fetcher}
+	session := newTestSession(t, map[string]string{}, args)
+
+	if err := OpalSync(args, "stars"); err != nil {
+		t.Fatal(err)
+	}
+
+	if stars := listTestFolder(t, session.vault, "github-stars"); len(stars) != 0 {
+		t.Errorf("audit mode wrote %v", stars)
+	}
+}
+
+func TestSyncFailsWithoutSourceTables(t *testing.T) {
+	// a fetch that writes nothing, as if coppermind failed silently
+	args := &OpalArgs{Fix: true, Fetcher: &OfflineFetcher{}}
+	newTestSession(t, map[string]string{}, args)
+
+	if err := OpalSync(args, "bookmarks"); err == nil {
+		t.Error("expected syncing from a database without coppermind's tables to fail")
+	}
+}
+
+func TestOfflineRunSkipsUnfetchedSources(t *testing.T) {
+	args := &OpalArgs{Fix: true, Offline: true}
+	session := newTestSession(t, map[string]string{"20220101 - Note.md": "Some prose\n"}, args)
+	fpath := filepath.Join(session.vault.dpath, "20220101 - Note.md")
+
+	if err := Opal(args); err != nil {
+		t.Fatal(err)
+	}
+
+	if content := readTestFile(t, session.vault, "20220101 - Note.md"); !strings.Contains(content, "# Note") {
+		t.Errorf("expected the note to be fixed, got %q", content)
+	}
+
+	// the run finished, so the fixed note is marked as processed
+	hash, processed, err := session.conn.GetHashes(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if processed == "" || processed != hash {
+		t.Errorf("expected the fixed note's hash to be stored, got %q for %q", processed, hash)
+	}
+}
+
+func TestSyncVaultsSharingDatabase(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	_, dbpath := newTestDb(t)
+
+	orphan := "20220101 - Gone.md"
+	first := newTestVault(t, map[string]string{orphan: "---\nbookmark_hash: gone\n---\n# Gone\n"})
+	second := newTestVault(t, map[string]string{})
+
+	fetcher := &fakeFetcher{
+		bookmarks: [][]interface{}{
+			{"Go - Wikipedia", "", "abc123", "https://en.wikipedia.org/wiki/Go", "", "yes", "go", "2022-02-07T21:51:23Z", "no"},
+		},
+	}
+
+	for _, vault := range []*ObsidianVault{first, second} {
+		args := &OpalArgs{Fpath: vault.dpath, Fix: true, Fetcher: fetcher, Db: dbpath,
+			Indexer: &BuiltinIndexer{}, Orphans: OrphanArchive}
+
+		if err := OpalSync(args, "bookmarks"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first vault's notes don't count as synced for the second
+	for _, vault := range []*ObsidianVault{first, second} {
+		if names := listTestFolder(t, vault, "pinboard-bookmarks"); len(names) != 1 {
+			t.Errorf("expected a bookmark note in %s, got %v", vault.dpath, names)
+		}
+	}
+
+	// and only the first vault archives its own orphan
+	if names := listTestFolder(t, second, ArchiveFolder); len(names) != 0 {
+		t.Errorf("the second vault archived another vault's notes: %v", names)
+	}
+
+	if names := listTestFolder(t, first, ArchiveFolder); len(names) != 1 || names[0] != orphan {
+		t.Errorf("expected the first vault to archive %s, got %v", orphan, names)
+	}
+}
+
+func TestImportCoppermindTables(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	shared, err := CoppermindDatabasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetcher := &fakeFetcher{
+		bookmarks: [][]interface{}{
+			{"Go - Wikipedia", "", "abc123", "https://en.wikipedia.org/wiki/Go", "", "yes", "go", "2022-02-07T21:51:23Z", "no"},
+		},
+	}
+
+	if err := fetcher.Fetch(shared); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, dbpath := newTestDb(t)
+
+	// importing again replaces the earlier copy, rather than duplicating rows
+	for run := 0; run < 2; run++ {
+		if err := ImportCoppermindTables(dbpath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bookmarks, err := conn.ListBookmarks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bookmarks) != 1 || bookmarks[0].hash != "abc123" {
+		t.Errorf("expected the shared bookmark to be imported once, got %d bookmark(s)", len(bookmarks))
+	}
+}
+
+func TestSyncUsesVaultDatabase(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	vault := newTestVault(t, map[string]string{"20220101 - Note.md": "# Note\n"})
+
+	fetcher := &fakeFetcher{
+		stars: [][]interface{}{
+			{"rgrannell1/opal", "Fix Obsidian notes", "rgrannell1", "https://github.com/rgrannell1/opal", "Go", "obsidian"},
+		},
+	}
+
+	args := &OpalArgs{Fpath: vault.dpath, Fix: true, Fetcher: fetcher, Indexer: &BuiltinIndexer{}}
+	if err := OpalSync(args, "stars"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(vault.dpath, ".opal", "opal.sqlite")); err != nil {
+		t.Errorf("expected the vault's own database: %v", err)
+	}
+
+	if stars := listTestFolder(t, vault, "github-stars"); len(stars) != 1 {
+		t.Errorf("expected one star note, got %v", stars)
+	}
+}
+
+func TestSelectSources(t *testing.T) {
+	sources := DefaultSources(nil)
+
+	for kind, name := range map[string]string{"bookmarks": "pinboard bookmark", "stars": "github star"} {
+		selected, err := SelectSources(sources, kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(selected) != 1 || selected[0].Name() != name {
+			t.Errorf("expected %s to select the %s source, got %d source(s)", kind, name, len(selected))
+		}
+	}
+
+	if _, err := SelectSources(sources, "toots"); err == nil || !strings.Contains(err.Error(), "bookmarks, stars") {
+		t.Errorf("expected an unknown kind to list the known kinds, got %v", err)
+	}
+}
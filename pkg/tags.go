@@ -0,0 +1,142 @@
+package opal
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+/*
+ * Prefixes for tags imported from external sources. Prefixes end in a
+ * `/`, so imported tags nest beneath a single parent in Obsidian's tag pane.
+ */
+type TagPrefixes struct {
+	Pinboard       string `yaml:"pinboard"`
+	GithubTopic    string `yaml:"github_topic"`
+	GithubLanguage string `yaml:"github_language"`
+}
+
+/*
+ * Construct the default tag prefixes
+ */
+func DefaultTagPrefixes() *TagPrefixes {
+	return &TagPrefixes{
+		Pinboard:       "pinboard/",
+		GithubTopic:    "github/topic/",
+		GithubLanguage: "github/language/",
+	}
+}
+
+var invalidTagChars = regexp.MustCompile(`[^\p{L}\p{N}_/-]+`)
+var repeatedDashes = regexp.MustCompile(`-{2,}`)
+var numericTag = regexp.MustCompile(`^[0-9/]+$`)
+
+// keep languages like C++ and C# distinct from C
+var symbolNames = strings.NewReplacer("+", "plus", "#", "sharp")
+
+/*
+ * Normalise a string to Obsidian tag characters: letters, numbers, `_`,
+ * `-` and `/` only
+ */
+func normaliseTagChars(tag string) string {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+	tag = symbolNames.Replace(tag)
+	tag = invalidTagChars.ReplaceAllString(strings.ToLower(tag), "-")
+	tag = repeatedDashes.ReplaceAllString(tag, "-")
+
+	segments := []string{}
+	for _, segment := range strings.Split(tag, "/") {
+		segment = strings.Trim(segment, "-")
+
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+/*
+ * Normalise a string to valid Obsidian tag syntax: letters, numbers,
+ * `_`, `-` and `/` only, with at least one non-numeric character. Returns
+ * an empty string if nothing usable remains.
+ */
+func NormaliseTag(tag string) string {
+	tag = normaliseTagChars(tag)
+
+	if numericTag.MatchString(tag) {
+		return ""
+	}
+
+	return tag
+}
+
+/*
+ * Normalise a list of tags beneath a prefix, dropping duplicate tags and
+ * tags that normalise to nothing, rather than leaving the bare prefix.
+ * Numeric tags are valid once prefixed.
+ */
+func PrefixTags(prefix string, tags []string) []string {
+	prefixed := []string{}
+	seen := NewSet([]string{})
+
+	for _, tag := range tags {
+		tag = normaliseTagChars(tag)
+		if tag == "" {
+			continue
+		}
+
+		tag = NormaliseTag(prefix + tag)
+
+		if tag != "" && !seen.Has(tag) {
+			seen.Add(tag)
+			prefixed = append(prefixed, tag)
+		}
+	}
+
+	return prefixed
+}
+
+/*
+ * Split a list stored as a string. Coppermind may store lists as
+ * JSON arrays, or as comma or space separated values.
+ */
+func SplitList(value string) []string {
+	values := []string{}
+	value = strings.TrimSpace(value)
+
+	if strings.HasPrefix(value, "[") {
+		if err := json.Unmarshal([]byte(value), &values); err == nil {
+			return values
+		}
+	}
+
+	return strings.FieldsFunc(value, func(char rune) bool {
+		return char == ',' || char == ' ' || char == '\t' || char == '\n'
+	})
+}
+
+/*
+ * Obsidian tags for a Pinboard bookmark's space-separated tags, and
+ * tags added by description rules
+ */
+func (book *PinboardBookmark) ObsidianTags(prefixes *TagPrefixes) []string {
+	tags := PrefixTags(prefixes.Pinboard, strings.Fields(book.tags))
+
+	// tags added by description rules are not prefixed
+	return append(tags, PrefixTags("", book.ApplyRules().Tags)...)
+}
+
+/*
+ * Obsidian tags for a starred repository's topics and language
+ *
+ */
+func (repo *StarredRepository) ObsidianTags(prefixes *TagPrefixes) []string {
+	tags := PrefixTags(prefixes.GithubTopic, SplitList(repo.Topics))
+
+	if repo.Language != "" {
+		tags = append(tags, PrefixTags(prefixes.GithubLanguage, []string{repo.Language})...)
+	}
+
+	return tags
+}
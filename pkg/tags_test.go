@@ -0,0 +1,31 @@
+package opal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormaliseTag(t *testing.T) {
+	cases := map[string]string{
+		"#Machine Learning": "machine-learning",
+		"c++":               "cplusplus",
+		"a//b--c":           "a/b-c",
+		"2022":              "",
+		"---":               "",
+	}
+
+	for tag, expected := range cases {
+		if actual := NormaliseTag(tag); actual != expected {
+			t.Errorf("NormaliseTag(%q) = %q, expected %q", tag, actual, expected)
+		}
+	}
+}
+
+func TestPrefixTagsDropsEmptyTags(t *testing.T) {
+	actual := PrefixTags("pinboard/", []string{"---", "!!!", "", "Go", "go", "2022"})
+	expected := []string{"pinboard/go", "pinboard/2022"}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("PrefixTags = %v, expected %v", actual, expected)
+	}
+}
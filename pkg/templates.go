@@ -0,0 +1,221 @@
+package opal
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * Built-in templates, used when no template file is found on disk
+ */
+//go:embed templates/*.txt
+var builtinTemplates embed.FS
+
+/*
+ * Where a source's template was found
+ */
+type TemplateLocation struct {
+	Fpath   string
+	Builtin bool
+	Content []byte
+}
+
+/*
+ * Describe a template location for humans
+ *
+ */
+func (loc *TemplateLocation) String() string {
+	if loc.Builtin {
+		return "(built-in) " + loc.Fpath
+	}
+
+	return loc.Fpath
+}
+
+/*
+ * Directories searched for templates, in priority order: an explicit
+ * templates directory, the vault's `.opal/templates` folder, then the user's
+ * config directory. Built-in templates are the final fallback.
+ */
+func TemplateSearchPath(templatesDir string, vaultDir string) []string {
+	dirs := []string{}
+
+	if templatesDir != "" {
+		dirs = append(dirs, templatesDir)
+	}
+
+	if vaultDir != "" {
+		dirs = append(dirs, filepath.Join(vaultDir, ".opal", "templates"))
+	}
+
+	if configDir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(configDir, "opal", "templates"))
+	}
+
+	return dirs
+}
+
+/*
+ * Find a template file on the search path, falling back to the
+ * built-in template of the same name
+ */
+func FindTemplate(dirs []string, fname string) (*TemplateLocation, error) {
+	for _, dir := range dirs {
+		fpath := filepath.Join(dir, fname)
+		content, err := os.ReadFile(fpath)
+
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &TemplateLocation{Fpath: fpath, Content: content}, nil
+	}
+
+	fpath := path.Join("templates", fname)
+	content, err := fs.ReadFile(builtinTemplates, fpath)
+	if err != nil {
+		return nil, errors.New("no template named " + fname + " found")
+	}
+
+	return &TemplateLocation{Fpath: fpath, Builtin: true, Content: content}, nil
+}
+
+/*
+ * Find and parse a source's template
+ *
+ */
+func LoadSourceTemplate(source Source, dirs []string) (*template.Template, *TemplateLocation, error) {
+	loc, err := FindTemplate(dirs, source.TemplateFile())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpl, err := source.ParseTemplate(string(loc.Content))
+	if err != nil {
+		return nil, loc, errors.Wrap(err, "failed parsing template "+loc.String())
+	}
+
+	return tmpl, loc, nil
+}
+
+/*
+ * Print the template chosen for each source
+ *
+ */
+func ListTemplates(out io.Writer, sources []Source, dirs []string) error {
+	for _, source := range sources {
+		loc, err := FindTemplate(dirs, source.TemplateFile())
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(out, "%-20s %s\n", source.Name(), loc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Check a template renders a record into a note whose frontmatter parses,
+ * and whose identity key round-trips; otherwise the next sync would not
+ * recognise the note and duplicate it.
+ */
+func CheckTemplate(source Source, tmpl *template.Template, record SourceRecord, prefixes *TagPrefixes) error {
+	rendered, err := record.Render(tmpl, record.Date()+StableSuffix(record.Identity(), 0), prefixes)
+	if err != nil {
+		return err
+	}
+
+	block, _ := SplitFrontmatter(rendered)
+	if len(block) == 0 {
+		return errors.New("rendered note has no frontmatter")
+	}
+
+	fm, err := ParseFrontmatter("", string(StripFrontmatterDelimiters(block)))
+	if err != nil {
+		return errors.Wrap(err, "rendered frontmatter is not valid YAML")
+	}
+
+	if !NewSet(fm.Values(source.Key())).Has(record.Identity()) {
+		return errors.New("frontmatter key " + source.Key() + " does not round-trip; expected '" + record.Identity() + "'")
+	}
+
+	if _, _, ok := FindFencedBlock(rendered, source.Block()); !ok {
+		return errors.New("rendered note has no ```" + source.Block() + " block")
+	}
+
+	return nil
+}
+
+/*
+ * Check each source's template against sample records: a few real rows when
+ * a database is available, and the built-in fixtures otherwise. Records are
+ * rendered with the configured tag prefixes, as sync would; nil prefixes use
+ * the defaults. Returns an error if any check fails.
+ */
+func CheckTemplates(out io.Writer, sources []Source, dirs []string, conn *OpalDb, prefixes *TagPrefixes, samples int) error {
+	failures := 0
+	if prefixes == nil {
+		prefixes = DefaultTagPrefixes()
+	}
+
+	for _, source := range sources {
+		tmpl, loc, err := LoadSourceTemplate(source, dirs)
+		if loc != nil {
+			fmt.Fprintf(out, "%s: %s\n", source.Name(), loc)
+		}
+
+		if err != nil {
+			failures++
+			fmt.Fprintf(out, "  FAIL  %v\n", err)
+			continue
+		}
+
+		records := []SourceRecord{}
+		kind := "row"
+
+		if conn != nil {
+			if rows, err := source.List(conn); err == nil {
+				records = rows
+			}
+		}
+
+		if len(records) > samples {
+			records = records[0:samples]
+		}
+
+		if len(records) == 0 {
+			records = source.Fixtures()
+			kind = "fixture"
+		}
+
+		for _, record := range records {
+			if err := CheckTemplate(source, tmpl, record, prefixes); err != nil {
+				failures++
+				fmt.Fprintf(out, "  FAIL  %s %s: %v\n", kind, record.Identity(), err)
+			} else {
+				fmt.Fprintf(out, "  ok    %s %s\n", kind, record.Identity())
+			}
+		}
+	}
+
+	if failures > 0 {
+		return errors.New(fmt.Sprint(failures) + " template check(s) failed")
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package opal
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckTemplatesUsesConfiguredPrefixes(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	dir := t.TempDir()
+
+	// the identity only round-trips when tags carry the configured prefix
+	writeTestFile(t, filepath.Join(dir, "pinboard-template.txt"), "---\n"+
+		`bookmark_hash: {{ if eq (printf "%.3s" (index .ObsidianTags 0)) "pb/" }}{{ .Hash | Yaml }}{{ end }}`+
+		"\n---\n```!pinboard\n```\n")
+
+	sources := []Source{&PinboardSource{Rules: defaultRuleSet}}
+	prefixes := DefaultTagPrefixes()
+	prefixes.Pinboard = "pb/"
+
+	if err := CheckTemplates(new(bytes.Buffer), sources, []string{dir}, nil, prefixes, 3); err != nil {
+		t.Errorf("expected the template to pass with the configured prefixes: %v", err)
+	}
+
+	if err := CheckTemplates(new(bytes.Buffer), sources, []string{dir}, nil, nil, 3); err == nil {
+		t.Error("expected the template to fail with the default prefixes")
+	}
+}
+
+func TestOpalCheckTemplatesReadsArgs(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	vault := newTestVault(t, map[string]string{})
+	_, dbpath := newTestDb(t)
+
+	writeTestFile(t, filepath.Join(vault.dpath, ".opal", "templates", "pinboard-template.txt"), "---\n"+
+		`bookmark_hash: {{ if eq (printf "%.3s" (index .ObsidianTags 0)) "pb/" }}{{ .Hash | Yaml }}{{ end }}`+
+		"\n---\n```!pinboard\n```\n")
+
+	prefixes := DefaultTagPrefixes()
+	prefixes.Pinboard = "pb/"
+
+	args := &OpalArgs{Fpath: vault.dpath, Db: dbpath, Prefixes: prefixes}
+	if err := OpalCheckTemplates(args); err != nil {
+		t.Errorf("expected opal templates check to use the configured prefixes: %v", err)
+	}
+}
@@ -2,51 +2,54 @@ package opal
 
 import (
 	"errors"
-	"fmt"
 	"os"
+	"sort"
+)
+
+const (
+	CheckMissing    = "missing"
+	CheckDuplicates = "duplicates"
 )
 
 /*
- * Assert no duplicate files (files with the same hash) are present. This can happen
+ * Check no duplicate files (files with the same hash) are present. This can happen
  * if Opal is broken & outputs duplicate files.
  *
  */
-func AssertNoDuplicates(conn *OpalDb) error {
-	hashes, err := conn.ListHashes()
+func CheckNoDuplicates(conn *OpalDb, dir string, report *ValidationReport) error {
+	hashes, err := conn.ListHashes(dir)
 	if err != nil {
 		return err
 	}
 
-	failed := false
-
-	ctr := NewCounter()
+	byHash := map[string][]string{}
 	for _, pair := range hashes {
 		// -- don't complain about empty files
 		if pair[1] != "0" {
-			ctr.Add(pair[1], pair[0])
+			byHash[pair[1]] = append(byHash[pair[1]], pair[0])
 		}
 	}
 
-	dupes := ctr.Duplicates()
+	for _, fpaths := range byHash {
+		if len(fpaths) < 2 {
+			continue
+		}
 
-	if len(dupes) > 0 {
-		failed = true
-		for _, dupe := range ctr.Duplicates() {
-			fmt.Println(dupe)
+		sort.Strings(fpaths)
+		for _, fpath := range fpaths[1:] {
+			report.Add(CheckDuplicates, SeverityError, fpath, "same content as "+fpaths[0]+"; this indicates files were accidentally duplicated")
 		}
 	}
 
-	if failed {
-		return errors.New("files with duplicate hashes found; this indicates files were accidentally duplicated")
-	} else {
-		return nil
-	}
+	return nil
 }
 
-func AssertNoMissing(conn *OpalDb) error {
-	missing := []string{}
-
-	pairs, err := conn.ListHashes()
+/*
+ * Check every file in the index exists
+ *
+ */
+func CheckNoMissing(conn *OpalDb, dir string, report *ValidationReport) error {
+	pairs, err := conn.ListHashes(dir)
 	if err != nil {
 		return err
 	}
@@ -55,33 +58,89 @@ func AssertNoMissing(conn *OpalDb) error {
 		fpath := pair[0]
 
 		if _, err := os.Stat(fpath); errors.Is(err, os.ErrNotExist) {
-			missing = append(missing, fpath)
-		}
-	}
-
-	if len(missing) > 0 {
-		for _, fpath := range missing {
-			fmt.Println(fpath)
+			report.Add(CheckMissing, SeverityError, fpath, "file is in the index, but does not exist")
 		}
-
-		return errors.New(fmt.Sprint(len(missing)) + " files that do not exist present in diatom file table")
 	}
 
 	return nil
 }
 
 /*
- * Validate the Obsidian repository.
- *
+ * Validate the Obsidian repository, running every enabled check and
+ * collecting findings into a report. Every check runs if none are
+ * configured. Errors are returned only if a check could not run.
  */
-func (vault *ObsidianVault) Validate(conn *OpalDb) error {
-	if err := AssertNoMissing(conn); err != nil {
-		return err
+func (vault *ObsidianVault) Validate(conn *OpalDb, checks *ValidationConfig) (*ValidationReport, error) {
+	report := NewValidationReport()
+
+	if checks == nil {
+		checks = &DefaultConfig().Validation
 	}
 
-	if err := AssertNoDuplicates(conn); err != nil {
-		return err
+	if checks.Missing {
+		report.AddCheck(CheckMissing)
+
+		if err := CheckNoMissing(conn, vault.dpath, report); err != nil {
+			return report, err
+		}
 	}
 
-	return nil
+	if checks.Duplicates {
+		report.AddCheck(CheckDuplicates)
+
+		if err := CheckNoDuplicates(conn, vault.dpath, report); err != nil {
+			return report, err
+		}
+	}
+
+	if checks.Frontmatter {
+		report.AddCheck(CheckFrontmatter)
+		report.AddCheck(CheckSchema)
+
+		if err := CheckFrontmatterSchemas(vault, checks.Schemas, report); err != nil {
+			return report, err
+		}
+	}
+
+	if checks.Filenames {
+		report.AddCheck(CheckFilenames)
+
+		if err := CheckNoBadFilenames(vault, checks.Exclude, report); err != nil {
+			return report, err
+		}
+	}
+
+	if !checks.Links && !checks.OrphanNotes && !checks.DeadEnds && !checks.Attachments {
+		return report, nil
+	}
+
+	graph, err := vault.NewLinkGraph()
+	if err != nil {
+		return report, err
+	}
+
+	if checks.Links {
+		report.AddCheck(CheckLinks)
+
+		if err := CheckNoBrokenLinks(graph, report); err != nil {
+			return report, err
+		}
+	}
+
+	if checks.OrphanNotes {
+		report.AddCheck(CheckOrphanNotes)
+		CheckNoOrphanNotes(vault, graph, checks.Exclude, report)
+	}
+
+	if checks.DeadEnds {
+		report.AddCheck(CheckDeadEnds)
+		CheckNoDeadEnds(vault, graph, checks.Exclude, report)
+	}
+
+	if checks.Attachments {
+		report.AddCheck(CheckAttachments)
+		CheckNoUnreferencedAttachments(vault, graph, checks.Exclude, report)
+	}
+
+	return report, nil
 }
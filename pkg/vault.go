@@ -1,26 +1,147 @@
 package opal
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"path/filepath"
+	"strings"
 )
 
 type ObsidianVault struct {
 	dpath string
+	plan  *Plan
 }
 
 /*
- * List markdown files in the vault
+ * Construct an Obsidian vault representation. All writes to the vault
+ * go through the supplied plan.
+ */
+func NewObsidianVault(dpath string, plan *Plan) *ObsidianVault {
+	return &ObsidianVault{
+		dpath: dpath,
+		plan:  plan,
+	}
+}
+
+/*
+ * A file-path relative to the vault directory, where possible
  *
  */
-func (vault *ObsidianVault) ListMarkdown() ([]string, error) {
-	return filepath.Glob(vault.dpath + "/*.md")
+func (vault *ObsidianVault) RelativePath(fpath string) string {
+	relpath, err := filepath.Rel(vault.dpath, fpath)
+	if err != nil {
+		return fpath
+	}
+
+	return relpath
 }
 
 /*
- * List markdown files modified since last processing
+ * Is a path inside a directory, rather than the directory itself or
+ * somewhere outside it?
+ */
+func withinDir(dir string, fpath string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+
+	fpath, err = filepath.Abs(fpath)
+	if err != nil {
+		return false
+	}
+
+	relpath, err := filepath.Rel(dir, fpath)
+	if err != nil {
+		return false
+	}
+
+	return relpath != "." && relpath != ".." && !strings.HasPrefix(relpath, ".."+string(filepath.Separator))
+}
+
+/*
+ * Is a path inside the vault?
  *
  */
-func (vault *ObsidianVault) ListModifiedMarkdown(conn *OpalDb) ([]*ObsidianNote, error) {
+func (vault *ObsidianVault) Contains(fpath string) bool {
+	return withinDir(vault.dpath, fpath)
+}
+
+/*
+ * List markdown files in the vault, including subfolders. Obsidian's own
+ * directories are skipped, as are paths matching the vault's `.opalignore`
+ */
+func (vault *ObsidianVault) ListMarkdown() ([]string, error) {
+	fpaths := []string{}
+
+	files, err := vault.ListFiles()
+	if err != nil {
+		return fpaths, err
+	}
+
+	for _, fpath := range files {
+		if filepath.Ext(fpath) == ".md" {
+			fpaths = append(fpaths, fpath)
+		}
+	}
+
+	return fpaths, nil
+}
+
+/*
+ * List every file in the vault, including attachments, skipping the same
+ * directories and ignored paths as ListMarkdown
+ */
+func (vault *ObsidianVault) ListFiles() ([]string, error) {
+	fpaths := []string{}
+
+	rules, err := LoadIgnoreRules(filepath.Join(vault.dpath, ".opalignore"))
+	if err != nil {
+		return fpaths, err
+	}
+
+	skipped := NewSet(IgnoredDirectories)
+
+	err = filepath.WalkDir(vault.dpath, func(fpath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fpath == vault.dpath {
+			return nil
+		}
+
+		relpath, err := filepath.Rel(vault.dpath, fpath)
+		if err != nil {
+			return err
+		}
+
+		relpath = filepath.ToSlash(relpath)
+
+		if entry.IsDir() {
+			if skipped.Has(entry.Name()) || rules.Ignored(relpath, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !rules.Ignored(relpath, false) {
+			fpaths = append(fpaths, fpath)
+		}
+
+		return nil
+	})
+
+	return fpaths, err
+}
+
+/*
+ * List markdown files modified since last processing. When forced, every
+ * note is listed regardless of stored hashes.
+ */
+func (vault *ObsidianVault) ListModifiedMarkdown(conn *OpalDb, force bool) ([]*ObsidianNote, error) {
 	modified := make([]*ObsidianNote, 0)
 	fpaths, err := vault.ListMarkdown()
 
@@ -40,6 +161,11 @@ func (vault *ObsidianVault) ListModifiedMarkdown(conn *OpalDb) ([]*ObsidianNote,
 			continue
 		}
 
+		if force {
+			modified = append(modified, note)
+			continue
+		}
+
 		changed, err := note.Changed(conn)
 		if err != nil {
 			return modified, err
@@ -54,17 +180,29 @@ func (vault *ObsidianVault) ListModifiedMarkdown(conn *OpalDb) ([]*ObsidianNote,
 }
 
 /*
- * Fix frontmatter for all markdown files in a vault
- *
+ * Fix frontmatter for all markdown files in a vault. Notes with malformed
+ * frontmatter are reported and skipped; the remaining notes are returned.
  */
-func (vault *ObsidianVault) FixFrontmatter(notes []*ObsidianNote, conn *OpalDb) error {
+func (vault *ObsidianVault) FixFrontmatter(out io.Writer, notes []*ObsidianNote, conn *OpalDb) ([]*ObsidianNote, error) {
+	fixed := []*ObsidianNote{}
+
 	for _, note := range notes {
-		if err := note.FixFrontmatter(conn); err != nil {
-			return err
+		err := note.FixFrontmatter(conn, vault.plan)
+
+		var invalid *FrontmatterError
+		if errors.As(err, &invalid) {
+			fmt.Fprintf(out, "skipping %s: %v\n", vault.RelativePath(note.fpath), invalid.Err)
+			continue
 		}
+
+		if err != nil {
+			return fixed, err
+		}
+
+		fixed = append(fixed, note)
 	}
 
-	return nil
+	return fixed, nil
 }
 
 /*
@@ -73,7 +211,7 @@ func (vault *ObsidianVault) FixFrontmatter(notes []*ObsidianNote, conn *OpalDb)
  */
 func (vault *ObsidianVault) FixTitle(notes []*ObsidianNote, conn *OpalDb) error {
 	for _, note := range notes {
-		if err := note.FixTitle(conn); err != nil {
+		if err := note.FixTitle(conn, vault.plan); err != nil {
 			return err
 		}
 	}